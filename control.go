@@ -3,7 +3,6 @@ package bfd
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"time"
 )
@@ -59,7 +58,7 @@ type BfdControlPacket struct {
 	ControlPlaneIndependent   bool
 	AuthPresent               bool
 	Demand                    bool
-	Multipoint                bool // Must always be zero
+	Multipoint                bool // Sent by multipoint heads (RFC 8562)
 	DetectMult                uint8
 	MyDiscriminator           uint32
 	YourDiscriminator         uint32
@@ -70,11 +69,11 @@ type BfdControlPacket struct {
 }
 
 var BfdControlPacketDefaults = BfdControlPacket{
-	Version:    1,
-	Diagnostic: DIAG_NONE,
-	State:      STATE_DOWN,
-	Poll:       false,
-	Final:      false,
+	Version:                   1,
+	Diagnostic:                DIAG_NONE,
+	State:                     STATE_DOWN,
+	Poll:                      false,
+	Final:                     false,
 	ControlPlaneIndependent:   false,
 	AuthPresent:               false,
 	Demand:                    false,
@@ -88,6 +87,80 @@ var BfdControlPacketDefaults = BfdControlPacket{
 	AuthHeader:                nil,
 }
 
+/*
+ * Decode and validate a received control packet
+ *
+ * Applies the reception checks of RFC 5880 section 6.8.6 that can be
+ * made without knowledge of the session. Packets which MUST be
+ * discarded are rejected with a *DecodeError.
+ */
+func Decode(data []byte) (*BfdControlPacket, error) {
+	if len(data) < 24 {
+		return nil, decodeError("Length", len(data), ErrPacketTooShort)
+	}
+
+	if (data[0]&0xE0)>>5 != 1 {
+		return nil, decodeError("Version", 0, ErrInvalidVersion)
+	}
+
+	length := int(data[3])
+	authPresent := data[1]&0x04 != 0
+	if length < 24 || (authPresent && length < 26) {
+		return nil, decodeError("Length", 3, ErrInvalidLength)
+	}
+	if length > len(data) {
+		return nil, decodeError("Length", 3, ErrPacketTooShort)
+	}
+
+	if authPresent && int(data[25]) != length-24 {
+		return nil, decodeError("Auth Len", 25, ErrAuthMismatch)
+	}
+	if !authPresent && length != 24 {
+		return nil, decodeError("A", 1, ErrAuthMismatch)
+	}
+
+	packet, err := decodeBfdPacket(data[:length])
+	if err == nil {
+		err = packet.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+/*
+ * Check the fields of a control packet against the reception rules
+ */
+func (p *BfdControlPacket) Validate() error {
+	if p.Version != 1 {
+		return decodeError("Version", 0, ErrInvalidVersion)
+	}
+	if p.DetectMult == 0 {
+		return decodeError("Detect Mult", 2, ErrZeroDetectMult)
+	}
+	// Multipoint heads never learn the discriminators of their tails
+	// (RFC 8562)
+	if p.Multipoint && p.YourDiscriminator != 0 {
+		return decodeError("M", 1, ErrMultipointSet)
+	}
+	if p.MyDiscriminator == 0 {
+		return decodeError("My Discriminator", 4, ErrZeroMyDiscriminator)
+	}
+	if !p.Multipoint && p.YourDiscriminator == 0 && p.State != STATE_DOWN && p.State != STATE_ADMIN_DOWN {
+		return decodeError("Your Discriminator", 8, ErrZeroYourDiscriminator)
+	}
+	if p.AuthPresent != (p.AuthHeader != nil) {
+		return decodeError("A", 1, ErrAuthMismatch)
+	}
+	if p.AuthPresent && p.AuthHeader.Type == BFD_AUTH_TYPE_SIMPLE && !validPassword(p.AuthHeader.AuthData) {
+		return decodeError("Password", 27, ErrInvalidAuthLength)
+	}
+
+	return nil
+}
+
 /*
  * Decode the control packet
  */
@@ -95,6 +168,10 @@ func decodeBfdPacket(data []byte) (*BfdControlPacket, error) {
 	var err error
 	packet := &BfdControlPacket{}
 
+	if len(data) < 24 {
+		return nil, decodeError("Length", len(data), ErrPacketTooShort)
+	}
+
 	packet.Version = uint8((data[0] & 0xE0) >> 5)
 	packet.Diagnostic = BfdDiagnostic(data[0] & 0x1F)
 
@@ -110,8 +187,8 @@ func decodeBfdPacket(data []byte) (*BfdControlPacket, error) {
 	packet.DetectMult = uint8(data[2])
 
 	length := uint8(data[3]) // No need to store this
-	if uint8(len(data)) != length {
-		err = errors.New("Packet length mis-match!")
+	if len(data) != int(length) {
+		err = decodeError("Length", 3, ErrInvalidLength)
 		return nil, err
 	}
 
@@ -124,14 +201,37 @@ func decodeBfdPacket(data []byte) (*BfdControlPacket, error) {
 	if packet.AuthPresent {
 		if len(data) > 24 {
 			packet.AuthHeader, err = decodeBfdAuthHeader(data[24:])
+			err = shiftDecodeError(err, 24)
 		} else {
-			err = errors.New("Header flag set, but packet too short!")
+			err = decodeError("A", 1, ErrAuthMismatch)
 		}
 	}
 
 	return packet, err
 }
 
+/*
+ * Marshal the packet, rejecting an authentication section that cannot
+ * be encoded. Transmitters use this rather than Marshal.
+ */
+func (p *BfdControlPacket) MarshalBinary() ([]byte, error) {
+	if err := p.validateAuth(); err != nil {
+		return nil, err
+	}
+	return p.Marshal(), nil
+}
+
+func (p *BfdControlPacket) validateAuth() error {
+	if p.AuthPresent && p.AuthHeader != nil {
+		return p.AuthHeader.validate()
+	}
+	return nil
+}
+
+/*
+ * Marshal the packet as it is, without checking the authentication
+ * section: an invalid one is encoded with a wrong Auth Len
+ */
 func (p *BfdControlPacket) Marshal() []byte {
 	var auth []byte
 	buf := bytes.NewBuffer([]uint8{})
@@ -2,6 +2,7 @@ package bfd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -144,6 +145,17 @@ var tests = []bfdControlPacketTestSet{
 			AuthHeader: nil,
 		},
 	},
+	{
+		Name: "Flag: Multipoint head",
+		Data: []byte{0x20, 0xc1, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Packet: BfdControlPacket{
+			Version: 1, Diagnostic: DIAG_NONE, State: STATE_UP,
+			Poll: false, Final: false, ControlPlaneIndependent: false, AuthPresent: false, Demand: false, Multipoint: true,
+			DetectMult: 3, MyDiscriminator: 1, YourDiscriminator: 0,
+			DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 0, RequiredMinEchoRxInterval: 0,
+			AuthHeader: nil,
+		},
+	},
 	{
 		Name: "Detection: Multiplier",
 		Data: []byte{0x20, 0xc0, 0x0a, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
@@ -294,3 +306,143 @@ func TestMarshalBfdControlPacket(t *testing.T) {
 		}
 	}
 }
+
+type bfdInvalidPacketTestSet struct {
+	Name string
+	Data []byte
+	Err  error
+}
+
+var invalidTests = []bfdInvalidPacketTestSet{
+	{
+		Name: "Empty",
+		Data: []byte{},
+		Err:  ErrPacketTooShort,
+	},
+	{
+		Name: "Truncated",
+		Data: []byte{0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01},
+		Err:  ErrPacketTooShort,
+	},
+	{
+		Name: "Version 0",
+		Data: []byte{0x00, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrInvalidVersion,
+	},
+	{
+		Name: "Length below minimum",
+		Data: []byte{0x20, 0x40, 0x03, 0x14, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrInvalidLength,
+	},
+	{
+		Name: "Length below minimum with auth",
+		Data: []byte{0x20, 0x44, 0x03, 0x19, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01},
+		Err:  ErrInvalidLength,
+	},
+	{
+		Name: "Length beyond payload",
+		Data: []byte{0x20, 0x40, 0x03, 0x1c, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrPacketTooShort,
+	},
+	{
+		Name: "Detect Mult zero",
+		Data: []byte{0x20, 0x40, 0x00, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrZeroDetectMult,
+	},
+	{
+		Name: "Multipoint set with Your Discriminator",
+		Data: []byte{0x20, 0x41, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrMultipointSet,
+	},
+	{
+		Name: "My Discriminator zero",
+		Data: []byte{0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrZeroMyDiscriminator,
+	},
+	{
+		Name: "Your Discriminator zero while Up",
+		Data: []byte{0x20, 0xc0, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00},
+		Err:  ErrZeroYourDiscriminator,
+	},
+	{
+		Name: "Auth bit clear with auth section",
+		Data: []byte{0x20, 0xc0, 0x03, 0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0b, 0x01, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64},
+		Err:  ErrAuthMismatch,
+	},
+	{
+		Name: "Auth Len mis-match",
+		Data: []byte{0x20, 0xc4, 0x03, 0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x01, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64},
+		Err:  ErrAuthMismatch,
+	},
+	{
+		Name: "Empty Simple Password",
+		Data: []byte{0x20, 0xc4, 0x03, 0x1b, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x01},
+		Err:  ErrInvalidAuthLength,
+	},
+	{
+		Name: "Simple Password too long",
+		Data: []byte{0x20, 0xc4, 0x03, 0x2c, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01, 0x14, 0x01, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67},
+		Err:  ErrInvalidAuthLength,
+	},
+}
+
+/*
+ * Packets which MUST be discarded are rejected with the matching error
+ */
+func TestDecodeInvalidBfdControlPacket(t *testing.T) {
+	for _, e := range invalidTests {
+		got, err := Decode(e.Data)
+		if !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+		if got != nil {
+			t.Errorf("Unexpected packet for test '%s': %#v", e.Name, got)
+		}
+	}
+}
+
+/*
+ * Valid packets decode identically through the exported API
+ */
+func TestDecodeValidBfdControlPacket(t *testing.T) {
+	for _, e := range tests {
+		if e.Packet.Validate() != nil {
+			continue
+		}
+		got, err := Decode(e.Data)
+		if err != nil {
+			t.Errorf("Unexpected error for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("BFD mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+/*
+ * Errors carry the offending field and offset
+ */
+func TestDecodeError(t *testing.T) {
+	data := []byte{
+		0x20, 0xc4, 0x03, 0x2c, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40,
+		0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0x14, 0x01, 0x00, // Keyed MD5 with a 12 byte digest
+		0x00, 0x00, 0x00, 0x01,
+		0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c,
+	}
+
+	_, err := Decode(data)
+
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("Expected a DecodeError, got %#v", err)
+	}
+	if de.Field != "Auth Key/Digest" || de.Offset != 32 || de.Reason != ErrInvalidAuthLength {
+		t.Errorf("DecodeError mismatch, got %#v", de)
+	}
+	if !errors.Is(err, ErrInvalidAuthLength) {
+		t.Errorf("Expected errors.Is to match ErrInvalidAuthLength")
+	}
+}
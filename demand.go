@@ -0,0 +1,74 @@
+package bfd
+
+/*
+ * Demand mode is active for the local system once it asked for it and
+ * both ends are Up: the peer then stops sending periodic packets, and
+ * connectivity is only checked with Poll Sequences (RFC 5880 section 6.6)
+ */
+func (s *Session) demandActive() bool {
+	return !s.multipoint() && s.status.DemandMode && s.status.SessionState == STATE_UP && s.status.RemoteSessionState == STATE_UP
+}
+
+/*
+ * The peer operates in Demand mode, so periodic packets towards it
+ * cease outside of Poll Sequences
+ */
+func (s *Session) remoteDemandActive() bool {
+	return s.status.RemoteDemandMode && s.status.SessionState == STATE_UP && s.status.RemoteSessionState == STATE_UP
+}
+
+/*
+ * Turn Demand mode on or off
+ *
+ * The change is announced with a Poll Sequence when the session is Up.
+ * Turning it off restarts the detection time so that the peer has time
+ * to resume periodic transmission.
+ */
+func (s *Session) SetDemandMode(demand bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wasActive := s.demandActive()
+	before := s.params()
+	s.status.DemandMode = demand
+
+	s.checkPoll(before)
+	if wasActive && !demand {
+		s.restartDetectionTimer()
+	}
+}
+
+/*
+ * Verify connectivity with a Poll Sequence
+ *
+ * The returned channel receives true when the peer answered with a
+ * Final, or false when the session went down first.
+ */
+func (s *Session) Verify() <-chan bool {
+	result := make(chan bool, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.SessionState != STATE_UP {
+		result <- false
+		return result
+	}
+
+	s.pollWaiters = append(s.pollWaiters, result)
+	if !s.polling {
+		s.startPoll(s.params())
+	}
+
+	return result
+}
+
+/*
+ * Report the outcome of a Poll Sequence to Verify callers
+ */
+func (s *Session) completePoll(ok bool) {
+	for _, c := range s.pollWaiters {
+		c <- ok
+	}
+	s.pollWaiters = nil
+}
@@ -0,0 +1,97 @@
+package bfd
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitForState(t *testing.T, s *Session, state BfdState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session did not reach state %d, got %d", state, s.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDemandMode(t *testing.T) {
+	var a, b *Session
+	var toA int64
+
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+
+	status.LocalDiscr = 1
+	status.DemandMode = true
+	a = NewSession(status, nil)
+	status.LocalDiscr = 2
+	status.DemandMode = false
+	b = NewSession(status, nil)
+
+	a.Start(func(p *BfdControlPacket) error { return b.Receive(p) })
+	b.Start(func(p *BfdControlPacket) error {
+		atomic.AddInt64(&toA, 1)
+		return a.Receive(p)
+	})
+	defer a.Stop()
+	defer b.Stop()
+
+	waitForState(t, a, STATE_UP)
+	waitForState(t, b, STATE_UP)
+	for a.PollInProgress() || b.PollInProgress() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The peer goes quiet, and A does not time out
+	time.Sleep(50 * time.Millisecond)
+	before := atomic.LoadInt64(&toA)
+	time.Sleep(200 * time.Millisecond)
+	if sent := atomic.LoadInt64(&toA) - before; sent != 0 {
+		t.Errorf("Peer sent %d periodic packets in Demand mode", sent)
+	}
+	if a.State() != STATE_UP {
+		t.Fatalf("Session went down in Demand mode")
+	}
+
+	select {
+	case ok := <-a.Verify():
+		if !ok {
+			t.Errorf("Verify failed with a live peer")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Verify did not complete")
+	}
+
+	// Back to Asynchronous mode, the peer resumes transmission
+	a.SetDemandMode(false)
+	for a.PollInProgress() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	before = atomic.LoadInt64(&toA)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&toA) == before {
+		t.Errorf("Peer did not resume periodic transmission")
+	}
+
+	// A silent peer fails verification
+	a.SetDemandMode(true)
+	for a.PollInProgress() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.Stop()
+
+	select {
+	case ok := <-a.Verify():
+		if ok {
+			t.Errorf("Verify succeeded with a silent peer")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Verify did not complete")
+	}
+	if got := a.Status().LocalDiag; got != DIAG_TIME_EXPIRED {
+		t.Errorf("Diagnostic mismatch, expected %d, got %d", DIAG_TIME_EXPIRED, got)
+	}
+}
@@ -0,0 +1,275 @@
+package bfd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"hash"
+)
+
+/*
+ * Hash function and Auth Key/Digest length of a keyed authentication
+ * type (RFC 5880 sections 6.7.3 and 6.7.4)
+ */
+func authDigest(t AuthenticationType) (func() hash.Hash, int) {
+	switch t {
+	case BFD_AUTH_TYPE_KEYED_MD5, BFD_AUTH_TYPE_METICULOUS_MD5, BFD_AUTH_TYPE_OPTIMIZED_MD5:
+		return md5.New, md5.Size
+	case BFD_AUTH_TYPE_KEYED_SHA1, BFD_AUTH_TYPE_METICULOUS_SHA1, BFD_AUTH_TYPE_OPTIMIZED_SHA1:
+		return sha1.New, sha1.Size
+	case BFD_AUTH_TYPE_KEYED_SHA256, BFD_AUTH_TYPE_METICULOUS_SHA256:
+		return sha256.New, sha256.Size
+	}
+
+	return nil, 0
+}
+
+/*
+ * HMAC types use the key as the HMAC key rather than placing it in the
+ * Auth Key/Digest field, which is zero while the digest is computed
+ */
+func authHMAC(t AuthenticationType) bool {
+	return t == BFD_AUTH_TYPE_KEYED_SHA256 || t == BFD_AUTH_TYPE_METICULOUS_SHA256
+}
+
+/*
+ * Longest key usable with a keyed authentication type
+ */
+func authKeySize(t AuthenticationType) int {
+	if authHMAC(t) {
+		return sha256.BlockSize
+	}
+
+	_, size := authDigest(t)
+	return size
+}
+
+/*
+ * Compute the digest of the packet with the key, padded with zeros,
+ * in place of the Auth Key/Digest field, or the HMAC of the packet with
+ * a zero Auth Key/Digest field
+ */
+func (p *BfdControlPacket) digest(key []byte) ([]byte, error) {
+	if !p.AuthPresent || p.AuthHeader == nil {
+		return nil, ErrAuthMismatch
+	}
+
+	newHash, size := authDigest(p.AuthHeader.Type)
+	if newHash == nil {
+		return nil, ErrUnsupportedAuthType
+	}
+	if len(key) > authKeySize(p.AuthHeader.Type) {
+		return nil, ErrInvalidAuthKey
+	}
+
+	c := *p
+	h := *p.AuthHeader
+	h.AuthData = make([]byte, size)
+	c.AuthHeader = &h
+
+	var sum hash.Hash
+	if authHMAC(h.Type) {
+		sum = hmac.New(newHash, key)
+	} else {
+		copy(h.AuthData, key)
+		sum = newHash()
+	}
+	sum.Write(c.Marshal())
+	return sum.Sum(nil), nil
+}
+
+/*
+ * Fill in the Password or Auth Key/Digest field of an outgoing packet
+ *
+ * The Auth Type, Auth Key ID and Sequence Number must already be set
+ * in AuthHeader.
+ */
+func (p *BfdControlPacket) Sign(key []byte) error {
+	if p.AuthPresent && p.AuthHeader != nil && p.AuthHeader.Type == BFD_AUTH_TYPE_SIMPLE {
+		if !validPassword(key) {
+			return ErrInvalidAuthKey
+		}
+		p.AuthHeader.AuthData = append([]byte{}, key...)
+		return nil
+	}
+
+	d, err := p.digest(key)
+	if err != nil {
+		return err
+	}
+
+	p.AuthHeader.AuthData = d
+	return nil
+}
+
+/*
+ * Check the Password or Auth Key/Digest field of a received packet
+ * against the key
+ */
+func (p *BfdControlPacket) Verify(key []byte) error {
+	if p.AuthPresent && p.AuthHeader != nil && p.AuthHeader.Type == BFD_AUTH_TYPE_SIMPLE {
+		if subtle.ConstantTimeCompare(key, p.AuthHeader.AuthData) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	d, err := p.digest(key)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(d, p.AuthHeader.AuthData) != 1 {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+/*
+ * Use a single key for the session
+ *
+ * A type of BFD_AUTH_TYPE_RESERVED turns authentication off.
+ */
+func (s *Session) SetAuthentication(t AuthenticationType, keyID uint8, key []byte) error {
+	if t == BFD_AUTH_TYPE_RESERVED {
+		s.SetKeyChain(nil)
+		return nil
+	}
+
+	kc := NewKeyChain()
+	if err := kc.Add(Key{ID: keyID, Type: t, Secret: key}); err != nil {
+		return err
+	}
+
+	s.SetKeyChain(kc)
+	return nil
+}
+
+/*
+ * Authenticate the session with the keys of kc, or turn
+ * authentication off when kc is nil
+ */
+func (s *Session) SetKeyChain(kc *KeyChain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyChain = kc
+	s.status.AuthSeqKnown = false
+	s.resetOptimizedAuth()
+	s.status.AuthType = BFD_AUTH_TYPE_RESERVED
+	if kc != nil {
+		if k, ok := kc.SendKey(); ok {
+			s.status.AuthType = k.Type
+		}
+	}
+}
+
+/*
+ * Add the authentication section to an outgoing packet
+ *
+ * The sequence number advances with every packet for the Meticulous
+ * types, and only when the packet contents change otherwise (RFC 5880
+ * section 6.7.3).
+ */
+func (s *Session) sign(p *BfdControlPacket) error {
+	if s.keyChain == nil {
+		return nil
+	}
+
+	k, ok := s.keyChain.SendKey()
+	if !ok {
+		return ErrUnknownAuthKey
+	}
+	s.status.AuthType = k.Type
+
+	if k.Type == BFD_AUTH_TYPE_SIMPLE {
+		p.AuthPresent = true
+		p.AuthHeader = &BfdAuthHeader{Type: k.Type, AuthKeyID: k.ID}
+		return p.Sign(k.Secret)
+	}
+
+	content := p.Marshal()
+	if k.Type.meticulous() {
+		if s.authSigned {
+			s.status.XmitAuthSeq++
+		}
+	} else if s.authSigned && !bytes.Equal(content, s.authLastContent) {
+		s.status.XmitAuthSeq++
+	}
+	s.authSigned = true
+	s.authLastContent = content
+
+	if k.Type.optimized() {
+		return s.signOptimized(p, k, content)
+	}
+
+	p.AuthPresent = true
+	p.AuthHeader = &BfdAuthHeader{
+		Type:           k.Type,
+		AuthKeyID:      k.ID,
+		SequenceNumber: s.status.XmitAuthSeq,
+	}
+	return p.Sign(k.Secret)
+}
+
+/*
+ * Check the authentication section of a received packet
+ */
+func (s *Session) authenticate(p *BfdControlPacket) error {
+	if p.AuthPresent != (s.keyChain != nil) {
+		return ErrAuthMismatch
+	}
+	if !p.AuthPresent {
+		return nil
+	}
+
+	k, ok := s.keyChain.AcceptKey(p.AuthHeader.AuthKeyID)
+	if !ok {
+		return ErrUnknownAuthKey
+	}
+
+	var err error
+	switch {
+	case k.Type.optimized():
+		err = s.authenticateOptimized(p, k)
+	case p.AuthHeader.Type != k.Type:
+		err = ErrAuthTypeMismatch
+	case !s.authSequenceValid(p):
+		err = ErrAuthSequence
+	default:
+		err = p.Verify(k.Secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.AuthHeader.Type != BFD_AUTH_TYPE_SIMPLE {
+		s.status.RcvAuthSeq = p.AuthHeader.SequenceNumber
+		s.status.AuthSeqKnown = true
+	}
+	return nil
+}
+
+/*
+ * Check a received sequence number against the replay window: no more
+ * than 3 * Detect Mult ahead of the last one, and strictly ahead of it
+ * for the Meticulous types
+ */
+func (s *Session) authSequenceValid(p *BfdControlPacket) bool {
+	if !s.status.AuthSeqKnown || p.AuthHeader.Type == BFD_AUTH_TYPE_SIMPLE {
+		return true
+	}
+
+	lo := uint32(0)
+	if p.AuthHeader.Type.meticulous() {
+		lo = 1
+	}
+	hi := 3 * uint32(p.DetectMult)
+
+	// Circular comparison
+	ahead := p.AuthHeader.SequenceNumber - s.status.RcvAuthSeq
+	return ahead >= lo && ahead <= hi
+}
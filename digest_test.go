@@ -0,0 +1,420 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type bfdDigestTestSet struct {
+	Name   string
+	Type   AuthenticationType
+	Digest []byte
+}
+
+/*
+ * Digests of a packet from discriminator 1 to 2, Up, Auth Key ID 1,
+ * Sequence Number 5 and key "secret"
+ */
+var digestTests = []bfdDigestTestSet{
+	{
+		Name:   "Keyed MD5",
+		Type:   BFD_AUTH_TYPE_KEYED_MD5,
+		Digest: []byte{0x46, 0xce, 0x52, 0xe1, 0x7b, 0x89, 0xb8, 0xe2, 0xe6, 0x1f, 0x7d, 0x24, 0xbd, 0x95, 0xca, 0x06},
+	},
+	{
+		Name:   "Meticulous Keyed MD5",
+		Type:   BFD_AUTH_TYPE_METICULOUS_MD5,
+		Digest: []byte{0xc5, 0xc6, 0x39, 0xef, 0x9f, 0x16, 0xc3, 0xb6, 0x22, 0xb4, 0x0e, 0xa6, 0x9a, 0x80, 0x77, 0x57},
+	},
+	{
+		Name:   "Keyed SHA1",
+		Type:   BFD_AUTH_TYPE_KEYED_SHA1,
+		Digest: []byte{0x71, 0xbe, 0xc9, 0xf4, 0x88, 0x8c, 0xfa, 0xbb, 0x65, 0x4e, 0x91, 0x77, 0x3d, 0x10, 0x5c, 0x82, 0x49, 0x30, 0xfa, 0xd9},
+	},
+	{
+		Name:   "Meticulous Keyed SHA1",
+		Type:   BFD_AUTH_TYPE_METICULOUS_SHA1,
+		Digest: []byte{0x55, 0x0c, 0x0d, 0x6a, 0x5e, 0x4d, 0xb8, 0x9a, 0x00, 0x2b, 0xa6, 0xd3, 0x51, 0x92, 0xf6, 0x45, 0x22, 0x3a, 0x69, 0x20},
+	},
+	{
+		Name:   "Keyed HMAC-SHA-256",
+		Type:   BFD_AUTH_TYPE_KEYED_SHA256,
+		Digest: []byte{0x22, 0x6d, 0x93, 0x3a, 0x0a, 0x12, 0x82, 0xeb, 0x2d, 0x73, 0xbb, 0x82, 0xac, 0x83, 0x9b, 0x37, 0x70, 0xa5, 0xdf, 0xd3, 0x8c, 0xb9, 0x82, 0x4a, 0xb1, 0xa4, 0x00, 0x79, 0xe2, 0x41, 0x5b, 0x58},
+	},
+	{
+		Name:   "Meticulous Keyed HMAC-SHA-256",
+		Type:   BFD_AUTH_TYPE_METICULOUS_SHA256,
+		Digest: []byte{0xbf, 0x7f, 0x9a, 0x8d, 0x98, 0x66, 0xe7, 0x53, 0x6b, 0x17, 0xc2, 0xa0, 0x72, 0xda, 0x52, 0xd3, 0xe7, 0x1c, 0x2c, 0x93, 0xd5, 0x14, 0xea, 0xad, 0x7a, 0xd5, 0xd1, 0x3a, 0x6b, 0xdf, 0x62, 0xe2},
+	},
+}
+
+func digestTestPacket(t AuthenticationType) *BfdControlPacket {
+	p := BfdControlPacketDefaults
+	p.State = STATE_UP
+	p.AuthPresent = true
+	p.MyDiscriminator = 1
+	p.YourDiscriminator = 2
+	p.AuthHeader = &BfdAuthHeader{Type: t, AuthKeyID: 1, SequenceNumber: 5}
+
+	return &p
+}
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("secret")
+
+	for _, e := range digestTests {
+		p := digestTestPacket(e.Type)
+		if err := p.Sign(key); err != nil {
+			t.Fatalf("Error signing for test '%s': %v", e.Name, err)
+		}
+		if !bytes.Equal(p.AuthHeader.AuthData, e.Digest) {
+			t.Errorf("Digest mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Digest, p.AuthHeader.AuthData)
+		}
+
+		// Round trip through the wire format
+		got, err := Decode(p.Marshal())
+		if err != nil {
+			t.Fatalf("Error decoding for test '%s': %v", e.Name, err)
+		}
+		if err := got.Verify(key); err != nil {
+			t.Errorf("Verification failed for test '%s': %v", e.Name, err)
+		}
+		if err := got.Verify([]byte("secreT")); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Wrong key accepted for test '%s', got %v", e.Name, err)
+		}
+
+		got.AuthHeader.SequenceNumber++
+		if err := got.Verify(key); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Modified packet accepted for test '%s', got %v", e.Name, err)
+		}
+	}
+}
+
+/*
+ * The next packet a session transmits, signed as on the transmit path
+ */
+func sentPacket(t *testing.T, s *Session) *BfdControlPacket {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.controlPacket()
+	if err := s.sign(p); err != nil {
+		t.Fatalf("Signing failed: %v", err)
+	}
+	return p
+}
+
+/*
+ * Known answer for the provisional HMAC-SHA-256 procedure, computed
+ * independently over the wire bytes with a zeroed digest field
+ */
+func TestKeyedSHA256Vector(t *testing.T) {
+	data := []byte{
+		0x20, 0xc4, 0x03, 0x40,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x0f, 0x42, 0x40,
+		0x00, 0x0f, 0x42, 0x40,
+		0x00, 0x00, 0x00, 0x00,
+		0x0a, 0x28, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x05,
+		0x22, 0x6d, 0x93, 0x3a, 0x0a, 0x12, 0x82, 0xeb,
+		0x2d, 0x73, 0xbb, 0x82, 0xac, 0x83, 0x9b, 0x37,
+		0x70, 0xa5, 0xdf, 0xd3, 0x8c, 0xb9, 0x82, 0x4a,
+		0xb1, 0xa4, 0x00, 0x79, 0xe2, 0x41, 0x5b, 0x58,
+	}
+
+	p, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify([]byte("secret")); err != nil {
+		t.Errorf("Known digest rejected: %v", err)
+	}
+
+	p.AuthHeader.AuthData = nil
+	if err := p.Sign([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Marshal(), data) {
+		t.Errorf("Digest mismatch, expected % x, got % x", data[32:], p.AuthHeader.AuthData)
+	}
+}
+
+func TestSessionAuthentication(t *testing.T) {
+	a := BfdStatusDefaults
+	a.LocalDiscr = 1
+	b := BfdStatusDefaults
+	b.LocalDiscr = 2
+
+	sa := NewSession(a, nil)
+	sb := NewSession(b, nil)
+	sa.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA1, 3, []byte("secret"))
+
+	if err := sb.Receive(sentPacket(t, sa)); !errors.Is(err, ErrAuthMismatch) {
+		t.Errorf("Authenticated packet accepted by unauthenticated session, got %v", err)
+	}
+
+	sb.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA1, 3, []byte("other"))
+	if err := sb.Receive(sentPacket(t, sa)); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Packet with wrong key accepted, got %v", err)
+	}
+
+	sb.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA1, 4, []byte("secret"))
+	if err := sb.Receive(sentPacket(t, sa)); !errors.Is(err, ErrUnknownAuthKey) {
+		t.Errorf("Packet with wrong key ID accepted, got %v", err)
+	}
+
+	sb.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA1, 3, []byte("secret"))
+	for i := 0; i < 3; i++ {
+		if err := sb.Receive(sentPacket(t, sa)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sa.Receive(sentPacket(t, sb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Authenticated sessions did not come up")
+	}
+
+	if err := sa.SetAuthentication(BFD_AUTH_TYPE_KEYED_MD5, 1, make([]byte, 17)); !errors.Is(err, ErrInvalidAuthKey) {
+		t.Errorf("Over-long MD5 key accepted, got %v", err)
+	}
+	if err := sa.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA256, 1, make([]byte, 64)); err != nil {
+		t.Errorf("HMAC-SHA-256 key of a block rejected: %v", err)
+	}
+	if err := sa.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA256, 1, make([]byte, 65)); !errors.Is(err, ErrInvalidAuthKey) {
+		t.Errorf("Over-long HMAC-SHA-256 key accepted, got %v", err)
+	}
+}
+
+func TestAuthSequenceNumbers(t *testing.T) {
+	for _, authType := range []AuthenticationType{BFD_AUTH_TYPE_KEYED_MD5, BFD_AUTH_TYPE_METICULOUS_MD5, BFD_AUTH_TYPE_KEYED_SHA256, BFD_AUTH_TYPE_METICULOUS_SHA256} {
+		status := BfdStatusDefaults
+		status.LocalDiscr = 1
+		sa := NewSession(status, nil)
+		sa.SetAuthentication(authType, 1, []byte("secret"))
+		status.LocalDiscr = 2
+		sb := NewSession(status, nil)
+		sb.SetAuthentication(authType, 1, []byte("secret"))
+
+		p1 := sentPacket(t, sa)
+		p2 := sentPacket(t, sa)
+		seq1 := p1.AuthHeader.SequenceNumber
+		seq2 := p2.AuthHeader.SequenceNumber
+
+		if authType.meticulous() && seq2 != seq1+1 {
+			t.Errorf("Meticulous sequence number not incremented, got %d then %d", seq1, seq2)
+		}
+		if !authType.meticulous() && seq2 != seq1 {
+			t.Errorf("Keyed sequence number changed without content change, got %d then %d", seq1, seq2)
+		}
+
+		if err := sb.Receive(p1); err != nil {
+			t.Fatal(err)
+		}
+		if st := sb.Status(); !st.AuthSeqKnown || st.RcvAuthSeq != seq1 {
+			t.Errorf("Received sequence number not recorded")
+		}
+
+		// Replays are only tolerated for the non-meticulous types
+		err := sb.Receive(p1)
+		if authType.meticulous() && !errors.Is(err, ErrAuthSequence) {
+			t.Errorf("Meticulous replay accepted, got %v", err)
+		}
+		if !authType.meticulous() && err != nil {
+			t.Errorf("Keyed repeat rejected: %v", err)
+		}
+
+		// Content changes advance the Keyed sequence number
+		sa.SetParameters(2000000, 1000000, 3)
+		p3 := sentPacket(t, sa)
+		if p3.AuthHeader.SequenceNumber == p2.AuthHeader.SequenceNumber {
+			t.Errorf("Sequence number not incremented on content change")
+		}
+
+		// Out of the 3 * Detect Mult window
+		p4 := digestTestPacket(authType)
+		p4.MyDiscriminator = 1
+		p4.YourDiscriminator = 0
+		p4.State = STATE_DOWN
+		p4.AuthHeader.SequenceNumber = seq1 + 10
+		p4.Sign([]byte("secret"))
+		if err := sb.Receive(p4); !errors.Is(err, ErrAuthSequence) {
+			t.Errorf("Sequence number beyond window accepted, got %v", err)
+		}
+		p4.AuthHeader.SequenceNumber = seq1 + 9
+		p4.Sign([]byte("secret"))
+		if err := sb.Receive(p4); err != nil {
+			t.Errorf("Sequence number at edge of window rejected: %v", err)
+		}
+	}
+}
+
+func TestControlPacketUnsigned(t *testing.T) {
+	status := BfdStatusDefaults
+	status.LocalDiscr = 1
+	s := NewSession(status, nil)
+	s.SetAuthentication(BFD_AUTH_TYPE_METICULOUS_SHA1, 1, []byte("secret"))
+
+	seq := sentPacket(t, s).AuthHeader.SequenceNumber
+	for i := 0; i < 3; i++ {
+		if p := s.ControlPacket(); p.AuthPresent || p.AuthHeader != nil {
+			t.Errorf("Inspected packet carries authentication")
+		}
+	}
+	if got := sentPacket(t, s).AuthHeader.SequenceNumber; got != seq+1 {
+		t.Errorf("Sequence number used up by ControlPacket, expected %d, got %d", seq+1, got)
+	}
+}
+
+func TestAuthSequenceForgotten(t *testing.T) {
+	status := BfdStatusDefaults
+	status.LocalDiscr = 2
+	status.RequiredMinRxInterval = 10000
+	s := NewSession(status, nil)
+	s.SetAuthentication(BFD_AUTH_TYPE_KEYED_SHA1, 1, []byte("secret"))
+	s.Start(func(p *BfdControlPacket) error { return nil })
+	defer s.Stop()
+
+	p := digestTestPacket(BFD_AUTH_TYPE_KEYED_SHA1)
+	p.State = STATE_DOWN
+	p.YourDiscriminator = 0
+	p.DesiredMinTxInterval = 10000
+	p.Sign([]byte("secret"))
+	if err := s.Receive(p); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.Status().AuthSeqKnown {
+		if time.Now().After(deadline) {
+			t.Fatalf("AuthSeqKnown not reset after twice the Detection Time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.State() != STATE_DOWN {
+		t.Errorf("Session not down after Detection Time")
+	}
+}
+
+func TestSimplePassword(t *testing.T) {
+	a := BfdStatusDefaults
+	a.LocalDiscr = 1
+	b := BfdStatusDefaults
+	b.LocalDiscr = 2
+
+	sa := NewSession(a, nil)
+	sb := NewSession(b, nil)
+	sa.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 1, []byte("password"))
+
+	p := sentPacket(t, sa)
+	if !bytes.Equal(p.AuthHeader.AuthData, []byte("password")) {
+		t.Errorf("Password not sent, got %q", p.AuthHeader.AuthData)
+	}
+	if got, err := Decode(p.Marshal()); err != nil || !reflect.DeepEqual(got.AuthHeader, p.AuthHeader) {
+		t.Errorf("Password does not survive encoding, got %#v, %v", got, err)
+	}
+
+	sb.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 1, []byte("passwore"))
+	if err := sb.Receive(p); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Wrong password accepted, got %v", err)
+	}
+	sb.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 2, []byte("password"))
+	if err := sb.Receive(p); !errors.Is(err, ErrUnknownAuthKey) {
+		t.Errorf("Wrong key ID accepted, got %v", err)
+	}
+	sb.SetAuthentication(BFD_AUTH_TYPE_KEYED_MD5, 1, []byte("password"))
+	if err := sb.Receive(p); !errors.Is(err, ErrAuthTypeMismatch) {
+		t.Errorf("Wrong Auth Type accepted, got %v", err)
+	}
+
+	sb.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 1, []byte("password"))
+	for i := 0; i < 3; i++ {
+		if err := sb.Receive(sentPacket(t, sa)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sa.Receive(sentPacket(t, sb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Sessions with Simple Password did not come up")
+	}
+
+	for _, password := range [][]byte{{}, make([]byte, 17)} {
+		if err := sa.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 1, password); !errors.Is(err, ErrInvalidAuthKey) {
+			t.Errorf("Password of %d bytes accepted, got %v", len(password), err)
+		}
+		p.AuthHeader.AuthData = password
+		if err := p.Validate(); !errors.Is(err, ErrInvalidAuthLength) {
+			t.Errorf("Packet with password of %d bytes valid, got %v", len(password), err)
+		}
+		if err := p.Sign(password); !errors.Is(err, ErrInvalidAuthKey) {
+			t.Errorf("Signed with password of %d bytes, got %v", len(password), err)
+		}
+		if _, err := p.MarshalBinary(); !errors.Is(err, ErrInvalidAuthLength) {
+			t.Errorf("Encoded password of %d bytes, got %v", len(password), err)
+		}
+		if _, err := p.AuthHeader.MarshalBinary(); !errors.Is(err, ErrInvalidAuthLength) {
+			t.Errorf("Encoded Auth header with password of %d bytes, got %v", len(password), err)
+		}
+	}
+
+	p.AuthHeader.AuthData = []byte("0123456789abcdef")
+	wire, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Password of 16 bytes not encoded: %v", err)
+	}
+	if wire[3] != 24+19 || wire[25] != 19 {
+		t.Errorf("Length mismatch for password of 16 bytes, got %d and %d", wire[3], wire[25])
+	}
+}
+
+/*
+ * Encoding accepts exactly the digest sizes decoding does
+ */
+func TestDigestLength(t *testing.T) {
+	tests := []struct {
+		Type AuthenticationType
+		Size int
+	}{
+		{BFD_AUTH_TYPE_KEYED_MD5, 16},
+		{BFD_AUTH_TYPE_METICULOUS_SHA1, 20},
+		{BFD_AUTH_TYPE_KEYED_SHA256, 32},
+		{BFD_AUTH_TYPE_METICULOUS_ISAAC, 4},
+	}
+
+	for _, e := range tests {
+		for _, size := range []int{e.Size - 1, e.Size + 1, 224} {
+			h := &BfdAuthHeader{Type: e.Type, AuthKeyID: 1, AuthData: make([]byte, size)}
+			if _, err := h.MarshalBinary(); !errors.Is(err, ErrInvalidAuthLength) {
+				t.Errorf("Encoded type %v with a digest of %d bytes, got %v", e.Type, size, err)
+			}
+		}
+
+		p := BfdControlPacketDefaults
+		p.MyDiscriminator = 1
+		p.AuthPresent = true
+		p.AuthHeader = &BfdAuthHeader{Type: e.Type, AuthKeyID: 1, AuthData: make([]byte, e.Size)}
+		wire, err := p.MarshalBinary()
+		if err != nil {
+			t.Errorf("Type %v with a digest of %d bytes not encoded: %v", e.Type, e.Size, err)
+			continue
+		}
+		if _, err := Decode(wire); err != nil {
+			t.Errorf("Encoded type %v not decoded: %v", e.Type, err)
+		}
+	}
+
+	h := &BfdAuthHeader{Type: 100, AuthKeyID: 1, AuthData: make([]byte, 16)}
+	if _, err := h.MarshalBinary(); !errors.Is(err, ErrUnsupportedAuthType) {
+		t.Errorf("Encoded an unknown type, got %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package bfd
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+const BFD_PORT_ECHO = 3785 // Echo packets, RFC 5881
+
+/*
+ * Create a transport receiving our own echo packets after the peer
+ * looped them back. Their TTL has been decremented along the way, so it
+ * is not checked.
+ */
+func NewEchoTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_ECHO,
+		MinTTL:  0,
+		Handler: handler,
+	}
+}
+
+/*
+ * Start the Echo function (RFC 5880 section 6.8.9)
+ *
+ * Echo packets are sent through tx at the greater of interval (in
+ * microseconds) and the peer's Required Min Echo RX Interval, whenever
+ * the session is Up and the peer is willing to loop them. While the
+ * Echo function is active the control packet rate is reduced by asking
+ * for a Required Min RX Interval of at least one second.
+ */
+func (s *Session) StartEcho(interval time.Duration, tx TransmitFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.params()
+	s.echoTx = tx
+	s.echoDesiredMinTxInterval = interval
+	s.checkPoll(before)
+
+	if s.echoTimer == nil {
+		s.echoTimer = time.AfterFunc(0, s.echoTimerExpired)
+	} else {
+		s.echoTimer.Reset(0)
+	}
+}
+
+/*
+ * Stop the Echo function and restore the control packet rate
+ */
+func (s *Session) StopEcho() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.params()
+	s.echoTx = nil
+	s.echoWasActive = false
+	if s.echoTimer != nil {
+		s.echoTimer.Stop()
+	}
+	s.checkPoll(before)
+}
+
+/*
+ * Report whether echo packets are currently being sent
+ */
+func (s *Session) EchoActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.echoActive()
+}
+
+func (s *Session) echoActive() bool {
+	return s.echoTx != nil && s.running &&
+		s.status.SessionState == STATE_UP &&
+		s.status.RemoteMinEchoRxInterval != 0
+}
+
+/*
+ * Note the return of one of our echo packets
+ */
+func (s *Session) ReceiveEcho(p *BfdControlPacket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.MyDiscriminator != s.status.LocalDiscr || p.YourDiscriminator != s.status.LocalDiscr {
+		return ErrUnknownDiscriminator
+	}
+
+	s.echoLastRx = time.Now()
+	return nil
+}
+
+/*
+ * Interval between echo packets, before jitter is applied
+ */
+func (s *Session) echoTransmitInterval() time.Duration {
+	interval := s.echoDesiredMinTxInterval
+	if s.status.RemoteMinEchoRxInterval > interval {
+		interval = s.status.RemoteMinEchoRxInterval
+	}
+
+	return microseconds(interval)
+}
+
+/*
+ * Time without a returned echo packet after which the session is
+ * declared down
+ */
+func (s *Session) echoDetectionTime() time.Duration {
+	return time.Duration(s.status.DetectMult) * s.echoTransmitInterval()
+}
+
+/*
+ * Required Min RX Interval to advertise, which is at least one second
+ * while the Echo function is active
+ */
+func (s *Session) requiredMinRxInterval() time.Duration {
+	if s.echoActive() && s.status.RequiredMinRxInterval < slowTxInterval {
+		return slowTxInterval
+	}
+
+	return s.status.RequiredMinRxInterval
+}
+
+func (s *Session) echoTimerExpired() {
+	var p *BfdControlPacket
+
+	s.mu.Lock()
+	if s.echoTx == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	active := s.echoActive()
+	if active && !s.echoWasActive {
+		s.echoLastRx = time.Now()
+	}
+	s.echoWasActive = active
+
+	if !active {
+		// Check again at the slow rate until the session is Up
+		s.echoTimer.Reset(microseconds(slowTxInterval))
+		s.mu.Unlock()
+		return
+	}
+
+	if time.Since(s.echoLastRx) > s.echoDetectionTime() {
+		old := s.status.SessionState
+		s.expire(DIAG_ECHO_FAILED)
+		s.checkPoll(s.params())
+		s.echoWasActive = false
+		state := s.status.SessionState
+		s.echoTimer.Reset(microseconds(slowTxInterval))
+		s.mu.Unlock()
+
+		s.notify(old, state)
+		return
+	}
+
+	p = s.controlPacket()
+	p.YourDiscriminator = s.status.LocalDiscr
+	s.echoTimer.Reset(applyJitter(s.echoTransmitInterval(), s.status.DetectMult))
+	tx := s.echoTx
+	s.mu.Unlock()
+
+	tx(p)
+}
+
+/*
+ * Start listening for returned echo packets on all addresses
+ */
+func (m *Manager) ListenEcho() error {
+	if m.echo == nil {
+		return ErrEchoUnsupported
+	}
+	return m.echo.Listen()
+}
+
+/*
+ * Start the Echo function for a session, sending echo packets
+ * addressed to local through the session's peer
+ *
+ * Echo packets are written with their IP header on a raw socket, which
+ * is only done for IPv4: sessions with an IPv6 peer or local address
+ * get ErrEchoUnsupported, and keep running on control packets alone.
+ */
+func (m *Manager) StartEcho(s *Session, local net.IP, interval time.Duration) error {
+	if m.echo == nil {
+		return ErrEchoUnsupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.demux.keys[s]
+	if !ok {
+		return ErrNoSession
+	}
+	if local == nil || local.IsUnspecified() {
+		return ErrNoLocalAddress
+	}
+
+	sender, err := DialEcho(local, net.ParseIP(key.peer))
+	if err != nil {
+		return err
+	}
+	if old, ok := m.echoSenders[s]; ok {
+		old.Close()
+	}
+	m.echoSenders[s] = sender
+
+	s.StartEcho(interval, sender.Transmit)
+	return nil
+}
+
+/*
+ * Stop the Echo function for a session
+ */
+func (m *Manager) StopEcho(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.StopEcho()
+	if sender, ok := m.echoSenders[s]; ok {
+		sender.Close()
+		delete(m.echoSenders, s)
+	}
+}
+
+func (m *Manager) handleEcho(r *ReceivedPacket) {
+	m.mu.Lock()
+	s, ok := m.demux.byDiscr[r.Packet.MyDiscriminator]
+	m.mu.Unlock()
+
+	err := ErrUnknownDiscriminator
+	if ok {
+		err = s.ReceiveEcho(r.Packet)
+	}
+	if err != nil {
+		m.countDrop(err, r.Peer)
+	}
+}
+
+/*
+ * Sends echo packets addressed to the local system towards the peer,
+ * which forwards them straight back
+ */
+type EchoSender struct {
+	fd      int
+	local   net.IP
+	peer    net.IP
+	srcPort uint16
+}
+
+/*
+ * Open a raw socket for echo packets from local, routed towards peer
+ *
+ * The IP header is built by EchoSender, so the destination address in
+ * the packet (ourselves) differs from the one used for routing. Only
+ * IPv4 is supported: ErrEchoUnsupported is returned for IPv6 addresses,
+ * and on platforms other than Linux.
+ */
+func DialEcho(local net.IP, peer net.IP) (*EchoSender, error) {
+	if local.To4() == nil || peer.To4() == nil {
+		return nil, ErrEchoUnsupported
+	}
+
+	fd, err := openEchoSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	span := BFD_SOURCE_PORT_MAX - BFD_SOURCE_PORT_MIN + 1
+	return &EchoSender{
+		fd:      fd,
+		local:   local.To4(),
+		peer:    peer.To4(),
+		srcPort: uint16(BFD_SOURCE_PORT_MIN + rand.Intn(span)),
+	}, nil
+}
+
+/*
+ * Send an echo packet, usable as a TransmitFunc
+ */
+func (s *EchoSender) Transmit(p *BfdControlPacket) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.send(s.encapsulate(b))
+}
+
+/*
+ * Wrap an echo payload in IPv4 and UDP headers, with both source and
+ * destination set to the local address
+ */
+func (s *EchoSender) encapsulate(payload []byte) []byte {
+	return encapsulateUDP(s.local, s.local, s.srcPort, BFD_PORT_ECHO, payload)
+}
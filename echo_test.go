@@ -0,0 +1,129 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEchoFunction(t *testing.T) {
+	var a, b *Session
+	var looping int32 = 1
+	down := make(chan BfdDiagnostic, 1)
+
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+
+	status.LocalDiscr = 1
+	a = NewSession(status, func(s *Session, old BfdState, new BfdState) {
+		if old == STATE_UP {
+			down <- s.Status().LocalDiag
+		}
+	})
+	status.LocalDiscr = 2
+	status.RequiredMinEchoRxInterval = 10000
+	// Slow control packets from a, so that b cannot time out before a
+	// notices the loss of its echo packets
+	status.RequiredMinRxInterval = 50000
+	b = NewSession(status, nil)
+
+	a.Start(func(p *BfdControlPacket) error { return b.Receive(p) })
+	b.Start(func(p *BfdControlPacket) error { return a.Receive(p) })
+	defer a.Stop()
+	defer b.Stop()
+
+	var echoed int32
+	a.StartEcho(20000, func(p *BfdControlPacket) error {
+		if atomic.LoadInt32(&looping) != 0 {
+			atomic.AddInt32(&echoed, 1)
+			return a.ReceiveEcho(p)
+		}
+		return nil
+	})
+
+	// Echo packets only start at the next slow rate check after the
+	// session came Up, wait for them so that their loss is timed from
+	// when looping stops
+	waitForState(t, a, STATE_UP)
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.EchoActive() || a.PollInProgress() || atomic.LoadInt32(&echoed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Echo function did not become active")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Control packets slow down while echo packets carry detection
+	if got := a.ControlPacket().RequiredMinRxInterval; got != 1000000 {
+		t.Errorf("Required Min RX Interval not raised, got %d", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if a.State() != STATE_UP {
+		t.Fatalf("Session went down with echo packets returning")
+	}
+
+	atomic.StoreInt32(&looping, 0)
+	select {
+	case diag := <-down:
+		if diag != DIAG_ECHO_FAILED {
+			t.Errorf("Diagnostic mismatch, expected %d, got %d", DIAG_ECHO_FAILED, diag)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Lost echo packets not detected")
+	}
+
+	a.StopEcho()
+	if got := a.ControlPacket().RequiredMinRxInterval; got != 10000 {
+		t.Errorf("Required Min RX Interval not restored, got %d", got)
+	}
+}
+
+func TestEchoEncapsulation(t *testing.T) {
+	s := &EchoSender{local: net.IPv4(192, 0, 2, 1).To4(), srcPort: 49152}
+	payload := []byte{1, 2, 3}
+	b := s.encapsulate(payload)
+
+	if checksum(b[0:20], 0) != 0 {
+		t.Errorf("Bad IPv4 header checksum")
+	}
+	if b[8] != BFD_TTL || b[9] != 17 {
+		t.Errorf("Bad TTL or protocol: %d, %d", b[8], b[9])
+	}
+	if !net.IP(b[12:16]).Equal(s.local) || !net.IP(b[16:20]).Equal(s.local) {
+		t.Errorf("Echo packet not addressed to ourselves")
+	}
+	if binary.BigEndian.Uint16(b[22:24]) != BFD_PORT_ECHO {
+		t.Errorf("Bad destination port")
+	}
+
+	pseudo := uint32(17) + uint32(len(b)-20)
+	for i := 12; i < 20; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if checksum(b[20:], pseudo) != 0 {
+		t.Errorf("Bad UDP checksum")
+	}
+}
+
+func TestEchoIPv6Unsupported(t *testing.T) {
+	m := NewManager()
+	defer m.Close()
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return tailSender{}, nil
+	}
+
+	s, err := m.AddSession(net.ParseIP("2001:db8::2"), nil, 0, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartEcho(s, net.ParseIP("2001:db8::1"), 50000); !errors.Is(err, ErrEchoUnsupported) {
+		t.Errorf("Echo started for an IPv6 session, got %v", err)
+	}
+	if s.EchoActive() {
+		t.Errorf("Echo function active")
+	}
+}
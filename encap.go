@@ -0,0 +1,256 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+/*
+ * Wrap a payload in UDP and IPv4 or IPv6 headers, for sockets that
+ * send whole IP packets
+ *
+ * Packets are sent with a TTL / Hop Limit of 255, and IPv4 packets with
+ * Don't Fragment set.
+ */
+func encapsulateUDP(src net.IP, dst net.IP, srcPort uint16, dstPort uint16, payload []byte) []byte {
+	return encapsulateIP(src, dst, srcPort, dstPort, BFD_TTL, false, payload)
+}
+
+/*
+ * Same as encapsulateUDP with the given TTL / Hop Limit, and optionally
+ * the Router Alert option (RFC 2113, RFC 2711)
+ */
+func encapsulateIP(src net.IP, dst net.IP, srcPort uint16, dstPort uint16, ttl uint8, routerAlert bool, payload []byte) []byte {
+	var b, udp []byte
+	var pseudo uint32
+
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		hlen := 20
+		if routerAlert {
+			hlen += 4
+		}
+		b = make([]byte, hlen+8+len(payload))
+		b[0] = 0x40 | byte(hlen/4) // Version 4
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		binary.BigEndian.PutUint16(b[6:8], 0x4000) // Don't Fragment
+		b[8] = ttl
+		b[9] = syscall.IPPROTO_UDP
+		copy(b[12:16], src4)
+		copy(b[16:20], dst4)
+		if routerAlert {
+			copy(b[20:24], []byte{0x94, 0x04, 0x00, 0x00})
+		}
+		binary.BigEndian.PutUint16(b[10:12], checksum(b[0:hlen], 0))
+		udp = b[hlen:]
+		pseudo = addressSum(b[12:20])
+	} else {
+		hlen := 40
+		if routerAlert {
+			hlen += 8
+		}
+		b = make([]byte, hlen+8+len(payload))
+		b[0] = 0x60 // Version 6
+		binary.BigEndian.PutUint16(b[4:6], uint16(len(b)-40))
+		b[6] = syscall.IPPROTO_UDP
+		b[7] = ttl
+		copy(b[8:24], src.To16())
+		copy(b[24:40], dst.To16())
+		if routerAlert {
+			// Hop-by-Hop Options header: Router Alert and a PadN
+			b[6] = 0
+			copy(b[40:48], []byte{syscall.IPPROTO_UDP, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00})
+		}
+		udp = b[hlen:]
+		pseudo = addressSum(b[8:40])
+	}
+
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	// Pseudo header: addresses, protocol and UDP length
+	pseudo += uint32(syscall.IPPROTO_UDP) + uint32(len(udp))
+	sum := checksum(udp, pseudo)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], sum)
+
+	return b
+}
+
+/*
+ * Sum of the 16 bit words of the addresses in a pseudo header
+ */
+func addressSum(addrs []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(addrs); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(addrs[i : i+2]))
+	}
+	return sum
+}
+
+/*
+ * Internet checksum (RFC 1071) over data, starting from initial
+ */
+func checksum(data []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+/*
+ * A UDP datagram taken out of its IPv4 or IPv6 header
+ */
+type udpDatagram struct {
+	Src     net.IP
+	Dst     net.IP
+	SrcPort uint16
+	DstPort uint16
+	TTL     int
+	Payload []byte
+
+	RouterAlert bool // The IP Router Alert option is present
+}
+
+/*
+ * Inner packets of encapsulations and those sent along LSPs must be
+ * addressed to 127/8, or its IPv4-mapped IPv6 form, so that they are
+ * never forwarded
+ */
+func loopbackDestinationValid(dst net.IP) bool {
+	dst4 := dst.To4()
+	return dst4 != nil && dst4[0] == 127
+}
+
+/*
+ * Parse an IPv4 or IPv6 packet carrying UDP, checking its checksums
+ *
+ * Offsets in the errors returned are relative to the start of b.
+ */
+func decapsulateUDP(b []byte) (*udpDatagram, error) {
+	var d udpDatagram
+	var udp []byte
+	var pseudo uint32
+	var offset int
+
+	if len(b) < 1 {
+		return nil, decodeError("IP Version", 0, ErrPacketTooShort)
+	}
+
+	switch b[0] >> 4 {
+	case 4:
+		hlen := int(b[0]&0x0f) * 4
+		if len(b) < 20 || hlen < 20 || len(b) < hlen {
+			return nil, decodeError("IP Header", 0, ErrPacketTooShort)
+		}
+		if checksum(b[0:hlen], 0) != 0 {
+			return nil, decodeError("IP Checksum", 10, ErrInvalidEncapsulation)
+		}
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if length < hlen || length > len(b) {
+			return nil, decodeError("IP Total Length", 2, ErrInvalidLength)
+		}
+		if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+			return nil, decodeError("IP Fragment", 6, ErrInvalidEncapsulation)
+		}
+		if b[9] != syscall.IPPROTO_UDP {
+			return nil, decodeError("IP Protocol", 9, ErrInvalidEncapsulation)
+		}
+		d.TTL = int(b[8])
+		d.RouterAlert = hasOption(b[20:hlen], 148, true)
+		d.Src = net.IP(append([]byte{}, b[12:16]...))
+		d.Dst = net.IP(append([]byte{}, b[16:20]...))
+		pseudo = addressSum(b[12:20])
+		udp = b[hlen:length]
+		offset = hlen
+	case 6:
+		if len(b) < 40 {
+			return nil, decodeError("IP Header", 0, ErrPacketTooShort)
+		}
+		length := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+		if length > len(b) {
+			return nil, decodeError("IP Payload Length", 4, ErrInvalidLength)
+		}
+		offset = 40
+		next, field := b[6], 6
+		if next == 0 {
+			// Hop-by-Hop Options header
+			if length < 48 {
+				return nil, decodeError("IP Hop-by-Hop Options", 40, ErrPacketTooShort)
+			}
+			hbh := 8 + 8*int(b[41])
+			if length < 40+hbh {
+				return nil, decodeError("IP Hop-by-Hop Options", 41, ErrInvalidLength)
+			}
+			next, field = b[40], 40
+			d.RouterAlert = hasOption(b[42:40+hbh], 5, false)
+			offset += hbh
+		}
+		if next != syscall.IPPROTO_UDP {
+			return nil, decodeError("IP Next Header", field, ErrInvalidEncapsulation)
+		}
+		d.TTL = int(b[7])
+		d.Src = net.IP(append([]byte{}, b[8:24]...))
+		d.Dst = net.IP(append([]byte{}, b[24:40]...))
+		pseudo = addressSum(b[8:40])
+		udp = b[offset:length]
+	default:
+		return nil, decodeError("IP Version", 0, ErrInvalidEncapsulation)
+	}
+
+	if len(udp) < 8 {
+		return nil, decodeError("UDP Header", offset, ErrPacketTooShort)
+	}
+	if int(binary.BigEndian.Uint16(udp[4:6])) != len(udp) {
+		return nil, decodeError("UDP Length", offset+4, ErrInvalidLength)
+	}
+	if binary.BigEndian.Uint16(udp[6:8]) != 0 || b[0]>>4 == 6 {
+		if checksum(udp, pseudo+uint32(syscall.IPPROTO_UDP)+uint32(len(udp))) != 0 {
+			return nil, decodeError("UDP Checksum", offset+6, ErrInvalidEncapsulation)
+		}
+	}
+
+	d.SrcPort = binary.BigEndian.Uint16(udp[0:2])
+	d.DstPort = binary.BigEndian.Uint16(udp[2:4])
+	d.Payload = udp[8:]
+	return &d, nil
+}
+
+/*
+ * Look for an option among IPv4 options, or IPv6 Hop-by-Hop options
+ * where the length does not count the type and length bytes
+ */
+func hasOption(options []byte, t byte, v4 bool) bool {
+	for i := 0; i < len(options); {
+		switch {
+		case options[i] == t:
+			return true
+		case v4 && options[i] == 0: // End of Option List
+			return false
+		case options[i] == 0 || v4 && options[i] == 1: // Pad1, No Operation
+			i++
+		case i+1 >= len(options):
+			return false
+		case v4:
+			if options[i+1] < 2 {
+				return false
+			}
+			i += int(options[i+1])
+		default:
+			i += 2 + int(options[i+1])
+		}
+	}
+	return false
+}
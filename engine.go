@@ -0,0 +1,228 @@
+package bfd
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+ * Called by a running session to send a control packet to the peer
+ */
+type TransmitFunc func(p *BfdControlPacket) error
+
+/*
+ * Slowest transmit rate allowed while the session is not Up
+ * (RFC 5880 section 6.8.3), in microseconds
+ */
+const slowTxInterval = time.Duration(1000000)
+
+/*
+ * Convert an interval carried in microseconds to a time.Duration
+ */
+func microseconds(d time.Duration) time.Duration {
+	return d * time.Microsecond
+}
+
+/*
+ * Start periodic transmission and detection timing for the session
+ */
+func (s *Session) Start(tx TransmitFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.tx = tx
+	s.txTimer = time.AfterFunc(0, s.transmitTimerExpired)
+}
+
+/*
+ * Stop all timers, the session state is left as is
+ */
+func (s *Session) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = false
+	if s.txTimer != nil {
+		s.txTimer.Stop()
+	}
+	if s.detectTimer != nil {
+		s.detectTimer.Stop()
+	}
+	if s.echoTimer != nil {
+		s.echoTimer.Stop()
+	}
+}
+
+/*
+ * Negotiated interval between transmitted control packets, before jitter
+ * is applied (RFC 5880 section 6.8.7)
+ */
+func (s *Session) TransmitInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transmitInterval()
+}
+
+func (s *Session) transmitInterval() time.Duration {
+	interval := s.txDesiredMinTxInterval()
+	if s.status.RemoteMinRxInterval > interval {
+		interval = s.status.RemoteMinRxInterval
+	}
+
+	return microseconds(interval)
+}
+
+/*
+ * Desired Min TX Interval to advertise and use, which may not be less
+ * than one second while the session is not Up
+ */
+func (s *Session) desiredMinTxInterval() time.Duration {
+	if s.status.SessionState != STATE_UP && s.status.DesiredMinTxInterval < slowTxInterval {
+		return slowTxInterval
+	}
+
+	return s.status.DesiredMinTxInterval
+}
+
+/*
+ * Time without a valid control packet after which the session is
+ * declared down (RFC 5880 section 6.8.4)
+ */
+func (s *Session) DetectionTime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.detectionTime()
+}
+
+func (s *Session) detectionTime() time.Duration {
+	// Tails cannot negotiate, the head's rate is all there is
+	if s.status.SessionType == SESSION_TYPE_MULTIPOINT_TAIL {
+		return time.Duration(s.status.RemoteDetectMult) * microseconds(s.status.RemoteDesiredMinTxInterval)
+	}
+	if s.demandActive() {
+		return time.Duration(s.status.DetectMult) * s.transmitInterval()
+	}
+
+	interval := s.detectRequiredMinRxInterval()
+	if s.status.RemoteDesiredMinTxInterval > interval {
+		interval = s.status.RemoteDesiredMinTxInterval
+	}
+
+	return time.Duration(s.status.RemoteDetectMult) * microseconds(interval)
+}
+
+/*
+ * Reduce an interval by a random 0-25%, or 10-25% when the local
+ * Detect Mult is one (RFC 5880 section 6.8.7)
+ */
+func applyJitter(interval time.Duration, detectMult uint8) time.Duration {
+	min := time.Duration(0)
+	if detectMult == 1 {
+		min = interval / 10
+	}
+	max := interval / 4
+
+	if max <= min {
+		return interval - min
+	}
+
+	return interval - min - time.Duration(rand.Int63n(int64(max-min)+1))
+}
+
+func (s *Session) transmitTimerExpired() {
+	var p *BfdControlPacket
+
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+
+	// No periodic transmission towards a peer which asked for none,
+	// or which is in Demand mode unless polling it. Multipoint heads
+	// transmit regardless and tails never do.
+	send := false
+	switch s.status.SessionType {
+	case SESSION_TYPE_POINT_TO_POINT:
+		send = s.status.RemoteMinRxInterval != 0 && (s.polling || !s.remoteDemandActive())
+	case SESSION_TYPE_MULTIPOINT_HEAD:
+		send = true
+	}
+	if send {
+		p = s.controlPacket()
+		if s.sign(p) != nil {
+			// No usable key, the peer would discard the packet
+			p = nil
+		}
+	}
+	s.txTimer.Reset(applyJitter(s.transmitInterval(), s.status.DetectMult))
+	tx := s.tx
+	s.mu.Unlock()
+
+	if p != nil && tx != nil {
+		// Lost packets are covered by the detection time
+		tx(p)
+	}
+}
+
+/*
+ * (Re)arm the detection timer after a valid packet was received
+ */
+func (s *Session) restartDetectionTimer() {
+	if !s.running {
+		return
+	}
+
+	s.lastRx = time.Now()
+	if s.detectTimer == nil {
+		s.detectTimer = time.AfterFunc(s.detectionTime(), s.detectTimerExpired)
+	} else {
+		s.detectTimer.Reset(s.detectionTime())
+	}
+}
+
+func (s *Session) detectTimerExpired() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+
+	// Nothing is expected from the peer in Demand mode unless polling
+	if s.demandActive() && !s.polling {
+		s.mu.Unlock()
+		return
+	}
+
+	// A packet may have arrived while this timer was firing
+	elapsed := time.Since(s.lastRx)
+	if remaining := s.detectionTime() - elapsed; remaining > 0 {
+		s.detectTimer.Reset(remaining)
+		s.mu.Unlock()
+		return
+	}
+
+	old := s.status.SessionState
+	s.expire(DIAG_TIME_EXPIRED)
+	s.checkPoll(s.params())
+	state := s.status.SessionState
+
+	// Forget the received sequence number after twice the Detection
+	// Time without packets (RFC 5880 section 6.8.1)
+	if elapsed >= 2*s.detectionTime() {
+		s.status.AuthSeqKnown = false
+		s.isaacRx = nil
+	} else if s.status.AuthSeqKnown {
+		s.detectTimer.Reset(2*s.detectionTime() - elapsed)
+	}
+	s.mu.Unlock()
+
+	s.notify(old, state)
+}
@@ -0,0 +1,92 @@
+package bfd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyJitter(t *testing.T) {
+	interval := 100 * time.Millisecond
+
+	for i := 0; i < 1000; i++ {
+		got := applyJitter(interval, 3)
+		if got < 75*time.Millisecond || got > interval {
+			t.Fatalf("Jittered interval %v out of range for Detect Mult 3", got)
+		}
+
+		got = applyJitter(interval, 1)
+		if got < 75*time.Millisecond || got > 90*time.Millisecond {
+			t.Fatalf("Jittered interval %v out of range for Detect Mult 1", got)
+		}
+	}
+}
+
+func TestNegotiatedIntervals(t *testing.T) {
+	status := BfdStatusDefaults
+	status.SessionState = STATE_UP
+	status.DesiredMinTxInterval = 50000
+	status.RequiredMinRxInterval = 20000
+	status.RemoteMinRxInterval = 100000
+	status.RemoteDesiredMinTxInterval = 30000
+	status.RemoteDetectMult = 5
+	s := NewSession(status, nil)
+
+	if got := s.TransmitInterval(); got != 100*time.Millisecond {
+		t.Errorf("Transmit interval mismatch, expected 100ms, got %v", got)
+	}
+	if got := s.DetectionTime(); got != 150*time.Millisecond {
+		t.Errorf("Detection time mismatch, expected 150ms, got %v", got)
+	}
+
+	// Never faster than once per second unless Up
+	s.SetAdminDown(DIAG_ADMIN_DOWN)
+	if got := s.TransmitInterval(); got != time.Second {
+		t.Errorf("Transmit interval mismatch while not Up, expected 1s, got %v", got)
+	}
+}
+
+/*
+ * Two running sessions connected back to back come up, and the
+ * survivor times out once its peer goes silent
+ */
+func TestSessionTimers(t *testing.T) {
+	var a, b *Session
+	down := make(chan BfdDiagnostic, 1)
+
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+
+	status.LocalDiscr = 1
+	a = NewSession(status, func(s *Session, old BfdState, new BfdState) {
+		if old == STATE_UP && new == STATE_DOWN {
+			down <- s.Status().LocalDiag
+		}
+	})
+	status.LocalDiscr = 2
+	b = NewSession(status, nil)
+
+	a.Start(func(p *BfdControlPacket) error { return b.Receive(p) })
+	b.Start(func(p *BfdControlPacket) error { return a.Receive(p) })
+	defer a.Stop()
+	defer b.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for a.State() != STATE_UP || b.State() != STATE_UP {
+		if time.Now().After(deadline) {
+			t.Fatalf("Sessions did not come up, got %d and %d", a.State(), b.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.Stop()
+
+	select {
+	case diag := <-down:
+		if diag != DIAG_TIME_EXPIRED {
+			t.Errorf("Diagnostic mismatch, expected %d, got %d", DIAG_TIME_EXPIRED, diag)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Session did not detect the silent peer")
+	}
+}
@@ -0,0 +1,88 @@
+package bfd
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+ * Reasons for discarding a received packet
+ *
+ * Every error returned while decoding or validating a packet wraps
+ * exactly one of these, so callers can test for them with errors.Is.
+ */
+var (
+	ErrPacketTooShort        = errors.New("Packet too short!")
+	ErrInvalidVersion        = errors.New("Unsupported protocol version!")
+	ErrInvalidLength         = errors.New("Invalid packet length!")
+	ErrZeroDetectMult        = errors.New("Detect Mult is zero!")
+	ErrMultipointSet         = errors.New("Multipoint bit is set!")
+	ErrZeroMyDiscriminator   = errors.New("My Discriminator is zero!")
+	ErrZeroYourDiscriminator = errors.New("Your Discriminator is zero!")
+	ErrAuthMismatch          = errors.New("Authentication section mis-match!")
+	ErrInvalidAuthLength     = errors.New("Invalid Auth Len!")
+	ErrUnsupportedAuthType   = errors.New("Unsupported Authentication type!")
+	ErrInvalidTTL            = errors.New("Received TTL / Hop Limit too low!")
+	ErrAuthFailed            = errors.New("Authentication failed!")
+	ErrPasswordMismatch      = errors.New("Simple Password mis-match!")
+	ErrAuthTypeMismatch      = errors.New("Auth Type does not match key!")
+	ErrUnknownAuthKey        = errors.New("Unknown Auth Key ID!")
+	ErrAuthSequence          = errors.New("Auth Sequence Number out of range!")
+	ErrInvalidEncapsulation  = errors.New("Invalid encapsulation!")
+	ErrReceiveFailed         = errors.New("Failed to receive packet!")
+)
+
+/*
+ * Reasons for not delivering a valid packet to a session, and for
+ * refusing to create one
+ */
+var (
+	ErrNoSession              = errors.New("No session for packet!")
+	ErrUnknownDiscriminator   = errors.New("Unknown Your Discriminator!")
+	ErrAddressMismatch        = errors.New("Packet addresses do not match session!")
+	ErrDuplicateSession       = errors.New("Session already exists!")
+	ErrNoLocalAddress         = errors.New("Session needs a local address!")
+	ErrNoInterface            = errors.New("Session needs an interface!")
+	ErrEchoUnsupported        = errors.New("Echo function not supported!")
+	ErrMultipointUnsupported  = errors.New("Multipoint sessions not supported!")
+	ErrUnsolicitedUnsupported = errors.New("Unsolicited BFD not supported!")
+	ErrOptionsTooLong         = errors.New("Geneve options too long!")
+	ErrSessionUnsupported     = errors.New("Session type not supported by manager!")
+	ErrNotEchoRequest         = errors.New("Not an LSP Ping echo request!")
+	ErrUnsolicitedLimit       = errors.New("Unsolicited session limit reached!")
+	ErrInvalidAuthKey         = errors.New("Invalid authentication key!")
+)
+
+/*
+ * Details of a rejected packet: the field at fault, its byte offset
+ * within the packet and the underlying reason
+ */
+type DecodeError struct {
+	Field  string
+	Offset int
+	Reason error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s (%s at offset %d)", e.Reason, e.Field, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Reason
+}
+
+func decodeError(field string, offset int, reason error) error {
+	return &DecodeError{Field: field, Offset: offset, Reason: reason}
+}
+
+/*
+ * Move the offset of a DecodeError produced while decoding a
+ * sub-section so that it is relative to the start of the packet
+ */
+func shiftDecodeError(err error, n int) error {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		de.Offset += n
+	}
+	return err
+}
@@ -0,0 +1,362 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const BFD_PORT_GENEVE = 6081 // Geneve, RFC 8926
+
+/*
+ * Protocol Types of the Geneve payloads carrying BFD (RFC 9521)
+ */
+const (
+	GENEVE_PROTOCOL_ETHERNET = 0x6558 // Transparent Ethernet Bridging
+	GENEVE_PROTOCOL_IPV4     = 0x0800
+	GENEVE_PROTOCOL_IPV6     = 0x86dd
+)
+
+/*
+ * Largest option data and options, as their length fields count 4 byte
+ * words in 5 and 6 bits (RFC 8926 section 3.5)
+ */
+const (
+	GENEVE_OPTION_DATA_MAX = 124 // Data of a single option
+	GENEVE_OPTIONS_MAX     = 252 // All options, including their headers
+)
+
+/*
+ * A Geneve option
+ *
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |          Option Class         |      Type     |R|R|R| Length  |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                 Variable-Length Option Data                   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * Data is padded to a multiple of 4 bytes when marshaled. The high bit
+ * of Type marks options that must be understood by the receiver.
+ */
+type GeneveOption struct {
+	Class uint16
+	Type  uint8
+	Data  []byte
+}
+
+func (o *GeneveOption) critical() bool {
+	return o.Type&0x80 != 0
+}
+
+/*
+ * Check that options fit in the length fields of the Geneve header and
+ * their own
+ */
+func validateGeneveOptions(opts []GeneveOption) error {
+	total := 0
+	for _, o := range opts {
+		if len(o.Data) > GENEVE_OPTION_DATA_MAX {
+			return ErrOptionsTooLong
+		}
+		total += 4 + (len(o.Data)+3)/4*4
+	}
+	if total > GENEVE_OPTIONS_MAX {
+		return ErrOptionsTooLong
+	}
+
+	return nil
+}
+
+/*
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |Ver|  Opt Len  |O|C|    Rsvd.  |          Protocol Type        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |        Virtual Network Identifier (VNI)       |    Reserved   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                    Variable-Length Options                    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |     Inner Ethernet header, for the Ethernet payload variant   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |         Inner IP and UDP headers, BFD Control Packet          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * Sessions run between Virtual Access Points (VAPs), whose addresses
+ * are those of the inner packet.
+ */
+type GenevePacket struct {
+	VNI      uint32
+	Options  []GeneveOption
+	Ethernet bool             // Ethernet rather than IP payload
+	DstMAC   net.HardwareAddr // Ethernet payload only
+	SrcMAC   net.HardwareAddr // Ethernet payload only
+	Src      net.IP           // Local VAP address
+	Dst      net.IP           // Remote VAP address
+	SrcPort  uint16           // Inner UDP source port
+	TTL      int              // Inner TTL / Hop Limit, 255 when sent
+	Packet   *BfdControlPacket
+}
+
+/*
+ * Marshal the Geneve header and everything it encapsulates
+ *
+ * The O bit is set, as BFD packets are control messages for the
+ * tunnel endpoint (RFC 9521 section 3). The options are unchecked: those
+ * beyond GENEVE_OPTION_DATA_MAX and GENEVE_OPTIONS_MAX do not fit.
+ */
+func (g *GenevePacket) Marshal() []byte {
+	var opts []byte
+	critical := false
+	for _, o := range g.Options {
+		padded := (len(o.Data) + 3) / 4 * 4
+		h := make([]byte, 4+padded)
+		binary.BigEndian.PutUint16(h[0:2], o.Class)
+		h[2] = o.Type
+		h[3] = uint8(padded/4) & 0x1f
+		copy(h[4:], o.Data)
+		opts = append(opts, h...)
+		critical = critical || o.critical()
+	}
+
+	ip := encapsulateUDP(g.Src, g.Dst, g.SrcPort, BFD_PORT_SINGLE_HOP, g.Packet.Marshal())
+	ethertype := uint16(GENEVE_PROTOCOL_IPV6)
+	if ip[0]>>4 == 4 {
+		ethertype = GENEVE_PROTOCOL_IPV4
+	}
+
+	b := make([]byte, 8, 8+len(opts)+14+len(ip))
+	b[0] = uint8(len(opts)/4) & 0x3f // Version 0
+	b[1] = 0x80                      // O
+	if critical {
+		b[1] |= 0x40 // C
+	}
+	binary.BigEndian.PutUint16(b[2:4], ethertype)
+	binary.BigEndian.PutUint32(b[4:8], g.VNI<<8)
+	b = append(b, opts...)
+
+	if g.Ethernet {
+		binary.BigEndian.PutUint16(b[2:4], GENEVE_PROTOCOL_ETHERNET)
+		eth := make([]byte, 14)
+		copy(eth[0:6], g.DstMAC)
+		copy(eth[6:12], g.SrcMAC)
+		binary.BigEndian.PutUint16(eth[12:14], ethertype)
+		b = append(b, eth...)
+	}
+
+	return append(b, ip...)
+}
+
+/*
+ * Decode a Geneve encapsulated control packet (RFC 9521 section 4)
+ *
+ * Packets with options marked critical are discarded, as none are
+ * understood. The inner packet must be addressed to port 3784 with a
+ * TTL / Hop Limit of 255.
+ */
+func DecodeGeneve(data []byte) (*GenevePacket, error) {
+	g := &GenevePacket{}
+
+	if len(data) < 8 {
+		return nil, decodeError("Geneve Header", len(data), ErrPacketTooShort)
+	}
+	if data[0]>>6 != 0 {
+		return nil, decodeError("Geneve Version", 0, ErrInvalidVersion)
+	}
+	if data[1]&0x80 == 0 {
+		return nil, decodeError("Geneve O", 1, ErrInvalidEncapsulation)
+	}
+	g.VNI = binary.BigEndian.Uint32(data[4:8]) >> 8
+
+	end := 8 + int(data[0]&0x3f)*4
+	if len(data) < end {
+		return nil, decodeError("Geneve Opt Len", 0, ErrPacketTooShort)
+	}
+	for offset := 8; offset < end; {
+		if end-offset < 4 {
+			return nil, decodeError("Geneve Option", offset, ErrInvalidLength)
+		}
+		o := GeneveOption{
+			Class: binary.BigEndian.Uint16(data[offset : offset+2]),
+			Type:  data[offset+2],
+		}
+		length := 4 + int(data[offset+3]&0x1f)*4
+		if offset+length > end {
+			return nil, decodeError("Geneve Option Length", offset+3, ErrInvalidLength)
+		}
+		if o.critical() {
+			return nil, decodeError("Geneve Option Type", offset+2, ErrInvalidEncapsulation)
+		}
+		o.Data = append([]byte{}, data[offset+4:offset+length]...)
+		g.Options = append(g.Options, o)
+		offset += length
+	}
+
+	inner := end
+	protocol := binary.BigEndian.Uint16(data[2:4])
+	if protocol == GENEVE_PROTOCOL_ETHERNET {
+		if len(data) < inner+14 {
+			return nil, decodeError("Inner Ethernet Header", len(data), ErrPacketTooShort)
+		}
+		g.Ethernet = true
+		g.DstMAC = net.HardwareAddr(append([]byte{}, data[inner:inner+6]...))
+		g.SrcMAC = net.HardwareAddr(append([]byte{}, data[inner+6:inner+12]...))
+		protocol = binary.BigEndian.Uint16(data[inner+12 : inner+14])
+		inner += 14
+	}
+	switch protocol {
+	case GENEVE_PROTOCOL_IPV4, GENEVE_PROTOCOL_IPV6:
+	default:
+		return nil, decodeError("Protocol Type", 2, ErrInvalidEncapsulation)
+	}
+
+	d, err := decapsulateUDP(data[inner:])
+	if err != nil {
+		return nil, shiftDecodeError(err, inner)
+	}
+	if d.DstPort != BFD_PORT_SINGLE_HOP {
+		return nil, decodeError("Inner Destination Port", inner, ErrInvalidEncapsulation)
+	}
+	if d.TTL != BFD_TTL {
+		return nil, decodeError("Inner TTL", inner, ErrInvalidTTL)
+	}
+	g.Src, g.Dst, g.SrcPort, g.TTL = d.Src, d.Dst, d.SrcPort, d.TTL
+
+	g.Packet, err = Decode(d.Payload)
+	if err != nil {
+		return nil, shiftDecodeError(err, len(data)-len(d.Payload))
+	}
+
+	return g, nil
+}
+
+/*
+ * Create a transport for Geneve encapsulated control packets
+ *
+ * The Peer and Local addresses of received packets are those of the
+ * VAPs, from the inner packet. The outer TTL is not checked, the inner
+ * one is checked by DecodeGeneve.
+ */
+func NewGeneveTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_GENEVE,
+		MinTTL:  0,
+		Handler: handler,
+		decode:  decodeGenevePacket,
+	}
+}
+
+func decodeGenevePacket(data []byte, r *ReceivedPacket) error {
+	g, err := DecodeGeneve(data)
+	if err != nil {
+		return err
+	}
+
+	r.Packet = g.Packet
+	r.VNI = g.VNI
+	r.Peer = &net.UDPAddr{IP: g.Src, Port: int(g.SrcPort)}
+	r.Local = g.Dst
+	return nil
+}
+
+/*
+ * A BFD session's path through a Geneve tunnel
+ */
+type GeneveTunnel struct {
+	Peer     net.IP // Remote tunnel endpoint, the outer destination
+	Local    net.IP // Local tunnel endpoint, the outer source
+	VNI      uint32
+	PeerVAP  net.IP           // Remote VAP address
+	LocalVAP net.IP           // Local VAP address
+	PeerMAC  net.HardwareAddr // Remote VAP MAC, nil for the IP payload variant
+	LocalMAC net.HardwareAddr // Local VAP MAC
+	Options  []GeneveOption   // Sent with every packet
+}
+
+/*
+ * Create a manager for BFD sessions over Geneve tunnels, identified by
+ * their VAP addresses and VNI
+ *
+ * AddSession and AddVNISession create sessions using the IP payload
+ * variant, with the tunnel endpoints acting as VAPs.
+ */
+func NewGeneveManager() *Manager {
+	m := newManager(false)
+	m.overlay = true
+	m.vap = true
+	m.transport = NewGeneveTransport(m.handle)
+	m.transport.Drop = m.countDrop
+	m.dialVNI = func(local net.IP, peer net.IP, vni uint32) (sessionSender, error) {
+		return m.dialGeneve(GeneveTunnel{Peer: peer, Local: local, VNI: vni, PeerVAP: peer, LocalVAP: local})
+	}
+
+	return m
+}
+
+func (m *Manager) dialGeneve(tun GeneveTunnel) (sessionSender, error) {
+	sender, err := m.transport.Dial(tun.Local, tun.Peer)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneveSender{sender: sender, tunnel: tun}, nil
+}
+
+/*
+ * Create and start a session between the VAPs of a Geneve tunnel
+ *
+ * The options of the tunnel must fit in a Geneve header, or
+ * ErrOptionsTooLong is returned.
+ */
+func (m *Manager) AddGeneveSession(tun GeneveTunnel, status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	if !m.vap {
+		return nil, ErrSessionUnsupported
+	}
+	if tun.LocalVAP == nil || tun.LocalVAP.IsUnspecified() {
+		return nil, ErrNoLocalAddress
+	}
+	if err := validateGeneveOptions(tun.Options); err != nil {
+		return nil, err
+	}
+
+	dial := func() (sessionSender, error) {
+		return m.dialGeneve(tun)
+	}
+	return m.addSession(m.key(tun.PeerVAP, tun.LocalVAP, 0, tun.VNI), dial, status, fn)
+}
+
+/*
+ * Transmits Geneve encapsulated control packets through a tunnel
+ */
+type GeneveSender struct {
+	sender *Sender
+	tunnel GeneveTunnel
+}
+
+/*
+ * Send a control packet, usable as a TransmitFunc
+ */
+func (s *GeneveSender) Transmit(p *BfdControlPacket) error {
+	if err := p.validateAuth(); err != nil {
+		return err
+	}
+
+	g := &GenevePacket{
+		VNI:      s.tunnel.VNI,
+		Options:  s.tunnel.Options,
+		Ethernet: s.tunnel.PeerMAC != nil,
+		DstMAC:   s.tunnel.PeerMAC,
+		SrcMAC:   s.tunnel.LocalMAC,
+		Src:      s.tunnel.LocalVAP,
+		Dst:      s.tunnel.PeerVAP,
+		SrcPort:  uint16(s.sender.LocalAddr().Port),
+		Packet:   p,
+	}
+
+	_, err := s.sender.conn.Write(g.Marshal())
+	return err
+}
+
+func (s *GeneveSender) Close() error {
+	return s.sender.Close()
+}
@@ -0,0 +1,177 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type geneveTestSet struct {
+	Name   string
+	Data   []byte
+	Packet GenevePacket
+}
+
+/*
+ * Headers as laid out by RFC 8926 section 3, carrying BFD as in
+ * RFC 9521 section 3
+ */
+var geneveTests = []geneveTestSet{
+	{
+		Name: "IPv4 payload",
+		Data: []byte{
+			0x03, 0x80, 0x08, 0x00, // Version 0, Opt Len 3, O, Protocol Type: IPv4
+			0x12, 0x34, 0x56, 0x00, // VNI: 0x123456
+			0x01, 0x04, 0x01, 0x02, // Option Class 0x0104, Type 1, Length 2
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // Option Data
+			0x45, 0x00, 0x00, 0x34, // IPv4, Total Length 52
+			0x00, 0x00, 0x40, 0x00, // Don't Fragment
+			0xff, 0x11, 0x67, 0xb6, // TTL 255, UDP, Header Checksum
+			0x0a, 0x00, 0x00, 0x01, // Source 10.0.0.1
+			0x0a, 0x00, 0x00, 0x02, // Destination 10.0.0.2
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0x74, 0xeb, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: GenevePacket{
+			VNI:     0x123456,
+			Options: []GeneveOption{{Class: 0x0104, Type: 0x01, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+			Src:     net.IP{10, 0, 0, 1},
+			Dst:     net.IP{10, 0, 0, 2},
+			SrcPort: 49152,
+			TTL:     255,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+	{
+		Name: "Ethernet payload over IPv6",
+		Data: []byte{
+			0x00, 0x80, 0x65, 0x58, // Version 0, Opt Len 0, O, Protocol Type: Ethernet
+			0x12, 0x34, 0x56, 0x00, // VNI: 0x123456
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x02, // Inner Destination MAC
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // Inner Source MAC
+			0x86, 0xdd, // EtherType: IPv6
+			0x60, 0x00, 0x00, 0x00, // IPv6
+			0x00, 0x20, 0x11, 0xff, // Payload Length 32, UDP, Hop Limit 255
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, // Source 2001:db8::1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, // Destination 2001:db8::2
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0x2d, 0x79, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: GenevePacket{
+			VNI:      0x123456,
+			Ethernet: true,
+			DstMAC:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+			SrcMAC:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+			Src:      net.ParseIP("2001:db8::1"),
+			Dst:      net.ParseIP("2001:db8::2"),
+			SrcPort:  49152,
+			TTL:      255,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+}
+
+func TestDecodeGeneve(t *testing.T) {
+	for _, e := range geneveTests {
+		got, err := DecodeGeneve(e.Data)
+		if err != nil {
+			t.Errorf("Error decoding for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("Geneve mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+func TestMarshalGeneve(t *testing.T) {
+	for _, e := range geneveTests {
+		if got := e.Packet.Marshal(); !bytes.Equal(e.Data, got) {
+			t.Errorf("Geneve mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Data, got)
+		}
+	}
+
+	// Option data is padded to 4 bytes
+	g := geneveTests[0].Packet
+	g.Options = []GeneveOption{{Class: 0x0104, Type: 0x01, Data: []byte{1}}}
+	got, err := DecodeGeneve(g.Marshal())
+	if err != nil || !reflect.DeepEqual(got.Options[0].Data, []byte{1, 0, 0, 0}) {
+		t.Errorf("Option not padded, got %#v, %v", got, err)
+	}
+}
+
+func TestDecodeInvalidGeneve(t *testing.T) {
+	critical := geneveTests[0].Packet
+	critical.Options = []GeneveOption{{Class: 0x0104, Type: 0x81}}
+	b := critical.Marshal()
+	if b[1]&0x40 == 0 {
+		t.Errorf("C bit not set for critical option")
+	}
+	if _, err := DecodeGeneve(b); !errors.Is(err, ErrInvalidEncapsulation) {
+		t.Errorf("Unknown critical option accepted, got %v", err)
+	}
+
+	tests := []struct {
+		Name   string
+		Modify func(b []byte) []byte
+		Err    error
+	}{
+		{"Truncated", func(b []byte) []byte { return b[:6] }, ErrPacketTooShort},
+		{"Version 1", func(b []byte) []byte { b[0] |= 0x40; return b }, ErrInvalidVersion},
+		{"O bit clear", func(b []byte) []byte { b[1] = 0; return b }, ErrInvalidEncapsulation},
+		{"Options beyond packet", func(b []byte) []byte { b[0] = 0x3f; return b[:40] }, ErrPacketTooShort},
+		{"Option overrun", func(b []byte) []byte { b[11] = 3; return b }, ErrInvalidLength},
+		{"Unknown Protocol Type", func(b []byte) []byte { b[2], b[3] = 0x88, 0x47; return b }, ErrInvalidEncapsulation},
+	}
+
+	for _, e := range tests {
+		_, err := DecodeGeneve(e.Modify(append([]byte{}, geneveTests[0].Data...)))
+		if !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+	}
+}
+
+func TestGeneveOptionLimits(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Options []GeneveOption
+		Err     error
+	}{
+		{"Largest option", []GeneveOption{{Data: make([]byte, 124)}}, nil},
+		{"Option too long", []GeneveOption{{Data: make([]byte, 125)}}, ErrOptionsTooLong},
+		{"Largest options", []GeneveOption{{Data: make([]byte, 124)}, {Data: make([]byte, 120)}}, nil},
+		{"Options too long", []GeneveOption{{Data: make([]byte, 124)}, {Data: make([]byte, 121)}}, ErrOptionsTooLong},
+	}
+
+	for _, e := range tests {
+		if err := validateGeneveOptions(e.Options); !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+	}
+
+	m := NewGeneveManager()
+	tun := GeneveTunnel{LocalVAP: net.IPv4(10, 0, 0, 1), PeerVAP: net.IPv4(10, 0, 0, 2), Options: tests[1].Options}
+	if _, err := m.AddGeneveSession(tun, BfdStatusDefaults, nil); !errors.Is(err, ErrOptionsTooLong) {
+		t.Errorf("Session with oversized option created, got %v", err)
+	}
+	if _, err := NewManager().AddGeneveSession(tun, BfdStatusDefaults, nil); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("Geneve session added outside a Geneve manager, got %v", err)
+	}
+}
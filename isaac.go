@@ -0,0 +1,169 @@
+package bfd
+
+import (
+	"encoding/binary"
+)
+
+/*
+ * ISAAC pseudo-random number generator (Bob Jenkins, 1996), used by
+ * the Meticulous Keyed ISAAC authentication type
+ */
+type isaac struct {
+	mm         [256]uint32
+	rsl        [256]uint32
+	aa, bb, cc uint32
+}
+
+func isaacMix(x *[8]uint32) {
+	x[0] ^= x[1] << 11
+	x[3] += x[0]
+	x[1] += x[2]
+	x[1] ^= x[2] >> 2
+	x[4] += x[1]
+	x[2] += x[3]
+	x[2] ^= x[3] << 8
+	x[5] += x[2]
+	x[3] += x[4]
+	x[3] ^= x[4] >> 16
+	x[6] += x[3]
+	x[4] += x[5]
+	x[4] ^= x[5] << 10
+	x[7] += x[4]
+	x[5] += x[6]
+	x[5] ^= x[6] >> 4
+	x[0] += x[5]
+	x[6] += x[7]
+	x[6] ^= x[7] << 8
+	x[1] += x[6]
+	x[7] += x[0]
+	x[7] ^= x[0] >> 9
+	x[2] += x[7]
+	x[0] += x[1]
+}
+
+/*
+ * Initialize the generator from up to 256 seed words and produce the
+ * first block of results
+ */
+func (r *isaac) seed(words []uint32) {
+	var x [8]uint32
+
+	*r = isaac{}
+	copy(r.rsl[:], words)
+
+	for i := range x {
+		x[i] = 0x9e3779b9
+	}
+	for i := 0; i < 4; i++ {
+		isaacMix(&x)
+	}
+
+	for _, src := range []*[256]uint32{&r.rsl, &r.mm} {
+		for i := 0; i < 256; i += 8 {
+			for j := range x {
+				x[j] += src[i+j]
+			}
+			isaacMix(&x)
+			copy(r.mm[i:i+8], x[:])
+		}
+	}
+
+	r.generate()
+}
+
+/*
+ * Produce the next block of 256 results in rsl
+ */
+func (r *isaac) generate() {
+	r.cc++
+	r.bb += r.cc
+
+	for i := 0; i < 256; i++ {
+		x := r.mm[i]
+		switch i % 4 {
+		case 0:
+			r.aa ^= r.aa << 13
+		case 1:
+			r.aa ^= r.aa >> 6
+		case 2:
+			r.aa ^= r.aa << 2
+		case 3:
+			r.aa ^= r.aa >> 16
+		}
+		r.aa += r.mm[(i+128)%256]
+		y := r.mm[(x>>2)%256] + r.aa + r.bb
+		r.mm[i] = y
+		r.bb = r.mm[(y>>10)%256] + x
+		r.rsl[i] = r.bb
+	}
+}
+
+/*
+ * The Auth Key/Digest values following a fully authenticated packet
+ *
+ * The generator is seeded from the key, the sender's My Discriminator
+ * and the Sequence Number of the fully authenticated packet; the packet
+ * with sequence number base+n carries the n-th result. Results for
+ * sequence numbers that may still arrive are kept until consumed, so
+ * that packets out of the replay window cannot disturb the stream.
+ */
+type isaacStream struct {
+	keyID   uint8
+	base    uint32
+	content []byte // Fully authenticated packet, without its auth section
+
+	gen   isaac
+	next  int      // Index into gen.rsl of the next result
+	pos   uint32   // Offset from base of the last consumed result
+	ahead []uint32 // Results following pos
+}
+
+func newIsaacStream(k Key, discr uint32, seq uint32, content []byte) *isaacStream {
+	words := []uint32{discr, seq}
+	secret := make([]byte, (len(k.Secret)+3)/4*4)
+	copy(secret, k.Secret)
+	for i := 0; i < len(secret); i += 4 {
+		words = append(words, binary.BigEndian.Uint32(secret[i:]))
+	}
+
+	st := &isaacStream{keyID: k.ID, base: seq, content: content}
+	st.gen.seed(words)
+	return st
+}
+
+/*
+ * Auth Key/Digest value for a sequence number no more than limit
+ * results beyond the last consumed one
+ */
+func (st *isaacStream) value(seq uint32, limit uint32) ([]byte, bool) {
+	n := seq - st.base - st.pos
+	if n == 0 || n > limit {
+		return nil, false
+	}
+
+	for uint32(len(st.ahead)) < n {
+		if st.next == len(st.gen.rsl) {
+			st.gen.generate()
+			st.next = 0
+		}
+		st.ahead = append(st.ahead, st.gen.rsl[st.next])
+		st.next++
+	}
+
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, st.ahead[n-1])
+	return v, true
+}
+
+/*
+ * Discard the results up to and including the one for seq
+ */
+func (st *isaacStream) consume(seq uint32) {
+	n := seq - st.base - st.pos
+	if n == 0 || n > uint32(len(st.ahead)) {
+		return
+	}
+
+	st.ahead = st.ahead[n:]
+	st.pos += n
+}
@@ -0,0 +1,137 @@
+package bfd
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * An authentication key and the periods during which it may be used
+ *
+ * Zero times leave the corresponding end of a period open.
+ */
+type Key struct {
+	ID          uint8
+	Type        AuthenticationType
+	Secret      []byte
+	SendStart   time.Time
+	SendEnd     time.Time
+	AcceptStart time.Time
+	AcceptEnd   time.Time
+}
+
+/*
+ * Report whether t falls within [start, end)
+ */
+func inPeriod(t time.Time, start time.Time, end time.Time) bool {
+	return (start.IsZero() || !t.Before(start)) && (end.IsZero() || t.Before(end))
+}
+
+/*
+ * Check the secret length against the authentication type
+ */
+func (k *Key) validate() error {
+	if k.Type == BFD_AUTH_TYPE_SIMPLE {
+		if !validPassword(k.Secret) {
+			return ErrInvalidAuthKey
+		}
+		return nil
+	}
+
+	if newHash, _ := authDigest(k.Type); newHash == nil {
+		return ErrUnsupportedAuthType
+	}
+	if len(k.Secret) == 0 || len(k.Secret) > authKeySize(k.Type) {
+		return ErrInvalidAuthKey
+	}
+
+	return nil
+}
+
+/*
+ * A set of keys shared by one or more sessions
+ *
+ * Outgoing packets are signed with the most recently started key in its
+ * send period, and received packets are accepted with any key in its
+ * accept period. Keys can be added and removed while sessions are
+ * running, so overlapping periods allow keys to be rolled over without
+ * taking sessions down.
+ */
+type KeyChain struct {
+	mu   sync.RWMutex
+	keys map[uint8]Key
+}
+
+func NewKeyChain() *KeyChain {
+	return &KeyChain{keys: make(map[uint8]Key)}
+}
+
+/*
+ * Add a key, replacing any existing key with the same ID
+ */
+func (kc *KeyChain) Add(k Key) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	k.Secret = append([]byte{}, k.Secret...)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	kc.keys[k.ID] = k
+	return nil
+}
+
+func (kc *KeyChain) Remove(id uint8) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	delete(kc.keys, id)
+}
+
+/*
+ * Key to sign outgoing packets with at the current time
+ */
+func (kc *KeyChain) SendKey() (Key, bool) {
+	return kc.sendKey(time.Now())
+}
+
+func (kc *KeyChain) sendKey(now time.Time) (Key, bool) {
+	var best Key
+	found := false
+
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+
+	for _, k := range kc.keys {
+		if !inPeriod(now, k.SendStart, k.SendEnd) {
+			continue
+		}
+		if !found || k.SendStart.After(best.SendStart) || (k.SendStart.Equal(best.SendStart) && k.ID > best.ID) {
+			best = k
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+/*
+ * Key with the given ID, if it may be used to accept packets at the
+ * current time
+ */
+func (kc *KeyChain) AcceptKey(id uint8) (Key, bool) {
+	return kc.acceptKey(id, time.Now())
+}
+
+func (kc *KeyChain) acceptKey(id uint8, now time.Time) (Key, bool) {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+
+	k, ok := kc.keys[id]
+	if !ok || !inPeriod(now, k.AcceptStart, k.AcceptEnd) {
+		return Key{}, false
+	}
+
+	return k, true
+}
@@ -0,0 +1,171 @@
+package bfd
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyChainLifetimes(t *testing.T) {
+	now := time.Now()
+	kc := NewKeyChain()
+	kc.Add(Key{ID: 1, Type: BFD_AUTH_TYPE_KEYED_SHA1, Secret: []byte("old"), SendEnd: now.Add(time.Hour), AcceptEnd: now.Add(2 * time.Hour)})
+	kc.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_KEYED_SHA1, Secret: []byte("new"), SendStart: now.Add(time.Hour), AcceptStart: now.Add(-time.Hour)})
+
+	tests := []struct {
+		at     time.Duration
+		send   uint8
+		accept []uint8
+	}{
+		{0, 1, []uint8{1, 2}},
+		{90 * time.Minute, 2, []uint8{1, 2}},
+		{3 * time.Hour, 2, []uint8{2}},
+	}
+
+	for _, test := range tests {
+		at := now.Add(test.at)
+		if k, ok := kc.sendKey(at); !ok || k.ID != test.send {
+			t.Errorf("At %v: expected send key %d, got %d", test.at, test.send, k.ID)
+		}
+		for id := uint8(1); id <= 2; id++ {
+			_, ok := kc.acceptKey(id, at)
+			expected := false
+			for _, a := range test.accept {
+				expected = expected || a == id
+			}
+			if ok != expected {
+				t.Errorf("At %v: key %d accepted %v, expected %v", test.at, id, ok, expected)
+			}
+		}
+	}
+
+	if _, ok := kc.sendKey(now.Add(-2 * time.Hour)); !ok {
+		t.Errorf("Open-ended key not used for sending")
+	}
+
+	kc.Remove(1)
+	if _, ok := kc.sendKey(now); ok {
+		t.Errorf("Removed key still used for sending")
+	}
+
+	if err := kc.Add(Key{ID: 3, Type: BFD_AUTH_TYPE_KEYED_MD5, Secret: make([]byte, 17)}); !errors.Is(err, ErrInvalidAuthKey) {
+		t.Errorf("Over-long MD5 key accepted, got %v", err)
+	}
+	if err := kc.Add(Key{ID: 3, Type: BFD_AUTH_TYPE_RESERVED, Secret: []byte("x")}); !errors.Is(err, ErrUnsupportedAuthType) {
+		t.Errorf("Key without a type accepted, got %v", err)
+	}
+}
+
+func TestKeyChainRollover(t *testing.T) {
+	a := BfdStatusDefaults
+	a.LocalDiscr = 1
+	b := BfdStatusDefaults
+	b.LocalDiscr = 2
+
+	kca := NewKeyChain()
+	kca.Add(Key{ID: 1, Type: BFD_AUTH_TYPE_METICULOUS_MD5, Secret: []byte("old")})
+	kcb := NewKeyChain()
+	kcb.Add(Key{ID: 1, Type: BFD_AUTH_TYPE_METICULOUS_MD5, Secret: []byte("old")})
+
+	changes := 0
+	fn := func(s *Session, old BfdState, new BfdState) {
+		if new != STATE_UP {
+			changes++
+		}
+	}
+	sa := NewSession(a, fn)
+	sa.SetKeyChain(kca)
+	sb := NewSession(b, fn)
+	sb.SetKeyChain(kcb)
+
+	exchange := func() {
+		t.Helper()
+		if err := sb.Receive(sentPacket(t, sa)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sa.Receive(sentPacket(t, sb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exchange()
+	exchange()
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Fatalf("Authenticated sessions did not come up")
+	}
+	changes = 0
+
+	// Accept the new key everywhere before anyone sends with it, also
+	// changing the algorithm
+	kca.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_METICULOUS_SHA1, Secret: []byte("new"), SendStart: time.Now().Add(time.Hour)})
+	kcb.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_METICULOUS_SHA1, Secret: []byte("new"), SendStart: time.Now().Add(time.Hour)})
+	exchange()
+
+	// One side switches, then the other
+	kca.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_METICULOUS_SHA1, Secret: []byte("new")})
+	p := sentPacket(t, sa)
+	if p.AuthHeader.AuthKeyID != 2 || p.AuthHeader.Type != BFD_AUTH_TYPE_METICULOUS_SHA1 {
+		t.Errorf("New key not used for sending")
+	}
+	exchange()
+	kcb.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_METICULOUS_SHA1, Secret: []byte("new")})
+	exchange()
+
+	// Retire the old key
+	kca.Remove(1)
+	kcb.Remove(1)
+	exchange()
+
+	if changes != 0 || sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Sessions flapped during key rollover")
+	}
+
+	p = sentPacket(t, sb)
+	p.AuthHeader.AuthKeyID = 1
+	p.Sign([]byte("old"))
+	if err := sa.Receive(p); !errors.Is(err, ErrUnknownAuthKey) {
+		t.Errorf("Packet signed with retired key accepted, got %v", err)
+	}
+}
+
+func TestNoSendKey(t *testing.T) {
+	kc := NewKeyChain()
+	kc.Add(Key{ID: 1, Type: BFD_AUTH_TYPE_KEYED_SHA1, Secret: []byte("later"), SendStart: time.Now().Add(time.Hour)})
+
+	status := BfdStatusDefaults
+	status.LocalDiscr = 1
+	s := NewSession(status, nil)
+	s.SetKeyChain(kc)
+
+	var mu sync.Mutex
+	var sent []*BfdControlPacket
+	s.Start(func(p *BfdControlPacket) error {
+		mu.Lock()
+		sent = append(sent, p)
+		mu.Unlock()
+		return nil
+	})
+	defer s.Stop()
+
+	// Without a key to send with, nothing goes out unsigned
+	s.transmitTimerExpired()
+	mu.Lock()
+	if len(sent) != 0 {
+		t.Errorf("Packet sent without a send key: %#v", sent[0])
+	}
+	mu.Unlock()
+
+	kc.Add(Key{ID: 2, Type: BFD_AUTH_TYPE_KEYED_SHA1, Secret: []byte("now")})
+	s.transmitTimerExpired()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) == 0 {
+		t.Fatalf("No packet sent with a send key")
+	}
+	for _, p := range sent {
+		if !p.AuthPresent || p.AuthHeader.AuthKeyID != 2 {
+			t.Errorf("Packet not signed with the send key: %#v", p)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const LSP_PING_PORT = 3503 // MPLS LSP Ping, RFC 8029
+
+type LspPingMessageType uint8
+
+const (
+	LSP_PING_ECHO_REQUEST LspPingMessageType = 1 // MPLS Echo Request
+	LSP_PING_ECHO_REPLY   LspPingMessageType = 2 // MPLS Echo Reply
+)
+
+type LspPingReplyMode uint8
+
+const (
+	LSP_PING_REPLY_NONE             LspPingReplyMode = 1 // Do not reply
+	LSP_PING_REPLY_UDP              LspPingReplyMode = 2 // Reply via an IPv4/IPv6 UDP packet
+	LSP_PING_REPLY_UDP_ROUTER_ALERT LspPingReplyMode = 3 // Same, with Router Alert
+	LSP_PING_REPLY_CONTROL_CHANNEL  LspPingReplyMode = 4 // Reply via application level control channel
+)
+
+const (
+	LSP_PING_RETURN_NONE   = 0 // No return code
+	LSP_PING_RETURN_EGRESS = 3 // Replying router is an egress for the FEC at stack-depth <RSC>
+)
+
+const (
+	LSP_PING_TLV_TARGET_FEC_STACK  = 1  // Target FEC Stack
+	LSP_PING_TLV_BFD_DISCRIMINATOR = 15 // BFD Discriminator, RFC 5884
+)
+
+const (
+	LSP_PING_FEC_LDP_IPV4 = 1 // LDP IPv4 prefix
+	LSP_PING_FEC_LDP_IPV6 = 2 // LDP IPv6 prefix
+)
+
+/*
+ * A TLV or sub-TLV, its value without padding
+ */
+type LspPingTLV struct {
+	Type  uint16
+	Value []byte
+}
+
+/*
+ * Target FEC Stack sub-TLV of an LDP prefix
+ */
+func LdpPrefixFEC(prefix *net.IPNet) LspPingTLV {
+	ones, _ := prefix.Mask.Size()
+	if ip := prefix.IP.To4(); ip != nil {
+		return LspPingTLV{Type: LSP_PING_FEC_LDP_IPV4, Value: append(append([]byte{}, ip...), byte(ones))}
+	}
+	return LspPingTLV{Type: LSP_PING_FEC_LDP_IPV6, Value: append(append([]byte{}, prefix.IP.To16()...), byte(ones))}
+}
+
+/*
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |         Version Number        |         Global Flags          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |  Message Type |   Reply mode  |  Return Code  | Return Subcode|
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                        Sender's Handle                        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                        Sequence Number                        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                    TimeStamp Sent (seconds)                   |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                  TimeStamp Sent (seconds fraction)            |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                  TimeStamp Received (seconds)                 |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                TimeStamp Received (seconds fraction)          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                            TLVs ...                           |
+ * .                                                               .
+ *
+ * TLVs are a 16 bit Type and Length followed by the value, zero padded
+ * to a multiple of four bytes.
+ */
+type LspPingPacket struct {
+	Version           uint16
+	GlobalFlags       uint16
+	MessageType       LspPingMessageType
+	ReplyMode         LspPingReplyMode
+	ReturnCode        uint8
+	ReturnSubcode     uint8
+	SendersHandle     uint32
+	SequenceNumber    uint32
+	TimestampSent     time.Time
+	TimestampReceived time.Time
+	FECStack          []LspPingTLV // Target FEC Stack sub-TLVs, nil if absent
+	BfdDiscriminator  uint32       // From the BFD Discriminator TLV, zero if absent
+	TLVs              []LspPingTLV // Any other TLVs
+}
+
+/*
+ * Echo request bootstrapping a BFD session for the FEC with local
+ * discriminator discr (RFC 5884 section 6)
+ */
+func NewLspPingRequest(fec []LspPingTLV, discr uint32) *LspPingPacket {
+	return &LspPingPacket{
+		Version:          1,
+		MessageType:      LSP_PING_ECHO_REQUEST,
+		ReplyMode:        LSP_PING_REPLY_UDP,
+		SendersHandle:    rand.Uint32(),
+		SequenceNumber:   1,
+		TimestampSent:    time.Now(),
+		FECStack:         fec,
+		BfdDiscriminator: discr,
+	}
+}
+
+/*
+ * Echo reply from the egress of the FEC, announcing its own
+ * discriminator discr
+ */
+func (p *LspPingPacket) Reply(discr uint32) *LspPingPacket {
+	return &LspPingPacket{
+		Version:           1,
+		MessageType:       LSP_PING_ECHO_REPLY,
+		ReplyMode:         p.ReplyMode,
+		ReturnCode:        LSP_PING_RETURN_EGRESS,
+		ReturnSubcode:     1,
+		SendersHandle:     p.SendersHandle,
+		SequenceNumber:    p.SequenceNumber,
+		TimestampSent:     p.TimestampSent,
+		TimestampReceived: time.Now(),
+		BfdDiscriminator:  discr,
+	}
+}
+
+/*
+ * Seconds since 1900 and binary fraction, zero for the zero time
+ */
+func ntpTimestamp(t time.Time) (uint32, uint32) {
+	if t.IsZero() {
+		return 0, 0
+	}
+
+	ns := t.Sub(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))
+	sec := ns / time.Second
+	frac := (uint64(ns%time.Second) << 32) / uint64(time.Second)
+	return uint32(sec), uint32(frac)
+}
+
+func ntpTime(sec uint32, frac uint32) time.Time {
+	if sec == 0 && frac == 0 {
+		return time.Time{}
+	}
+
+	ns := (uint64(frac)*uint64(time.Second) + 1<<31) >> 32
+	return time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(sec)*time.Second + time.Duration(ns))
+}
+
+func marshalTLVs(tlvs []LspPingTLV) []byte {
+	var b []byte
+	for _, t := range tlvs {
+		b = binary.BigEndian.AppendUint16(b, t.Type)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.Value)))
+		b = append(b, t.Value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+/*
+ * Split TLVs, offsets in the errors returned are relative to b
+ */
+func decodeTLVs(b []byte) ([]LspPingTLV, error) {
+	var tlvs []LspPingTLV
+	for i := 0; i < len(b); {
+		if len(b)-i < 4 {
+			return nil, decodeError("TLV Header", i, ErrPacketTooShort)
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2 : i+4]))
+		if len(b)-i-4 < n {
+			return nil, decodeError("TLV Length", i+2, ErrInvalidLength)
+		}
+		tlvs = append(tlvs, LspPingTLV{
+			Type:  binary.BigEndian.Uint16(b[i : i+2]),
+			Value: append([]byte{}, b[i+4:i+4+n]...),
+		})
+		i += 4 + (n+3)/4*4
+	}
+	return tlvs, nil
+}
+
+func (p *LspPingPacket) Marshal() []byte {
+	b := make([]byte, 32)
+
+	binary.BigEndian.PutUint16(b[0:2], p.Version)
+	binary.BigEndian.PutUint16(b[2:4], p.GlobalFlags)
+	b[4] = uint8(p.MessageType)
+	b[5] = uint8(p.ReplyMode)
+	b[6] = p.ReturnCode
+	b[7] = p.ReturnSubcode
+	binary.BigEndian.PutUint32(b[8:12], p.SendersHandle)
+	binary.BigEndian.PutUint32(b[12:16], p.SequenceNumber)
+	sec, frac := ntpTimestamp(p.TimestampSent)
+	binary.BigEndian.PutUint32(b[16:20], sec)
+	binary.BigEndian.PutUint32(b[20:24], frac)
+	sec, frac = ntpTimestamp(p.TimestampReceived)
+	binary.BigEndian.PutUint32(b[24:28], sec)
+	binary.BigEndian.PutUint32(b[28:32], frac)
+
+	var tlvs []LspPingTLV
+	if p.FECStack != nil {
+		tlvs = append(tlvs, LspPingTLV{Type: LSP_PING_TLV_TARGET_FEC_STACK, Value: marshalTLVs(p.FECStack)})
+	}
+	if p.BfdDiscriminator != 0 {
+		tlvs = append(tlvs, LspPingTLV{Type: LSP_PING_TLV_BFD_DISCRIMINATOR, Value: binary.BigEndian.AppendUint32(nil, p.BfdDiscriminator)})
+	}
+	tlvs = append(tlvs, p.TLVs...)
+
+	return append(b, marshalTLVs(tlvs)...)
+}
+
+/*
+ * Decode an MPLS echo request or reply
+ *
+ * The Target FEC Stack and BFD Discriminator TLVs are decoded, other
+ * TLVs are kept as they are.
+ */
+func DecodeLspPing(data []byte) (*LspPingPacket, error) {
+	p := &LspPingPacket{}
+
+	if len(data) < 32 {
+		return nil, decodeError("Length", len(data), ErrPacketTooShort)
+	}
+
+	p.Version = binary.BigEndian.Uint16(data[0:2])
+	if p.Version != 1 {
+		return nil, decodeError("Version Number", 0, ErrInvalidVersion)
+	}
+	p.GlobalFlags = binary.BigEndian.Uint16(data[2:4])
+	p.MessageType = LspPingMessageType(data[4])
+	p.ReplyMode = LspPingReplyMode(data[5])
+	p.ReturnCode = data[6]
+	p.ReturnSubcode = data[7]
+	p.SendersHandle = binary.BigEndian.Uint32(data[8:12])
+	p.SequenceNumber = binary.BigEndian.Uint32(data[12:16])
+	p.TimestampSent = ntpTime(binary.BigEndian.Uint32(data[16:20]), binary.BigEndian.Uint32(data[20:24]))
+	p.TimestampReceived = ntpTime(binary.BigEndian.Uint32(data[24:28]), binary.BigEndian.Uint32(data[28:32]))
+
+	tlvs, err := decodeTLVs(data[32:])
+	if err != nil {
+		return nil, shiftDecodeError(err, 32)
+	}
+
+	offset := 32
+	for _, t := range tlvs {
+		switch t.Type {
+		case LSP_PING_TLV_TARGET_FEC_STACK:
+			p.FECStack, err = decodeTLVs(t.Value)
+			if err != nil {
+				return nil, shiftDecodeError(err, offset+4)
+			}
+			if p.FECStack == nil {
+				p.FECStack = []LspPingTLV{}
+			}
+		case LSP_PING_TLV_BFD_DISCRIMINATOR:
+			if len(t.Value) != 4 {
+				return nil, decodeError("BFD Discriminator", offset+2, ErrInvalidLength)
+			}
+			p.BfdDiscriminator = binary.BigEndian.Uint32(t.Value)
+		default:
+			p.TLVs = append(p.TLVs, t)
+		}
+		offset += 4 + (len(t.Value)+3)/4*4
+	}
+
+	return p, nil
+}
@@ -0,0 +1,132 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type lspPingTestSet struct {
+	Name   string
+	Data   []byte
+	Packet LspPingPacket
+}
+
+/*
+ * Messages as laid out by RFC 8029 section 3, with the BFD
+ * Discriminator TLV of RFC 5884 section 6.1
+ */
+var lspPingTests = []lspPingTestSet{
+	{
+		Name: "Echo request",
+		Data: []byte{
+			0x00, 0x01, 0x00, 0x00, // Version 1, no Global Flags
+			0x01, 0x02, 0x00, 0x00, // Echo Request, Reply via UDP
+			0x01, 0x02, 0x03, 0x04, // Sender's Handle
+			0x00, 0x00, 0x00, 0x01, // Sequence Number: 1
+			0xe9, 0x3d, 0xfb, 0xa5, 0x00, 0x00, 0x00, 0x00, // TimeStamp Sent: 2024-01-02 03:04:05 UTC
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // TimeStamp Received: none
+			0x00, 0x01, 0x00, 0x0c, // Target FEC Stack, Length 12
+			0x00, 0x01, 0x00, 0x05, // LDP IPv4 prefix, Length 5
+			0xc0, 0x00, 0x02, 0x00, 0x18, 0x00, 0x00, 0x00, // 192.0.2.0/24, padding
+			0x00, 0x0f, 0x00, 0x04, // BFD Discriminator, Length 4
+			0x12, 0x34, 0x56, 0x78, // 0x12345678
+			0x00, 0x14, 0x00, 0x05, // TLV 20, Length 5
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x00, 0x00, // Value, padding
+		},
+		Packet: LspPingPacket{
+			Version:          1,
+			MessageType:      LSP_PING_ECHO_REQUEST,
+			ReplyMode:        LSP_PING_REPLY_UDP,
+			SendersHandle:    0x01020304,
+			SequenceNumber:   1,
+			TimestampSent:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			FECStack:         []LspPingTLV{{Type: LSP_PING_FEC_LDP_IPV4, Value: []byte{192, 0, 2, 0, 24}}},
+			BfdDiscriminator: 0x12345678,
+			TLVs:             []LspPingTLV{{Type: 20, Value: []byte{1, 2, 3, 4, 5}}},
+		},
+	},
+	{
+		Name: "Echo reply",
+		Data: []byte{
+			0x00, 0x01, 0x00, 0x00, // Version 1, no Global Flags
+			0x02, 0x02, 0x03, 0x01, // Echo Reply, Reply via UDP, Egress at depth 1
+			0x01, 0x02, 0x03, 0x04, // Sender's Handle
+			0x00, 0x00, 0x00, 0x01, // Sequence Number: 1
+			0xe9, 0x3d, 0xfb, 0xa5, 0x00, 0x00, 0x00, 0x00, // TimeStamp Sent: 2024-01-02 03:04:05 UTC
+			0xe9, 0x3d, 0xfb, 0xa6, 0x80, 0x00, 0x00, 0x00, // TimeStamp Received: 2024-01-02 03:04:06.5 UTC
+			0x00, 0x0f, 0x00, 0x04, // BFD Discriminator, Length 4
+			0x9a, 0xbc, 0xde, 0xf0, // 0x9abcdef0
+		},
+		Packet: LspPingPacket{
+			Version:           1,
+			MessageType:       LSP_PING_ECHO_REPLY,
+			ReplyMode:         LSP_PING_REPLY_UDP,
+			ReturnCode:        LSP_PING_RETURN_EGRESS,
+			ReturnSubcode:     1,
+			SendersHandle:     0x01020304,
+			SequenceNumber:    1,
+			TimestampSent:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			TimestampReceived: time.Date(2024, 1, 2, 3, 4, 6, 500000000, time.UTC),
+			BfdDiscriminator:  0x9abcdef0,
+		},
+	},
+}
+
+func TestDecodeLspPing(t *testing.T) {
+	for _, e := range lspPingTests {
+		got, err := DecodeLspPing(e.Data)
+		if err != nil {
+			t.Errorf("Error decoding for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("LSP Ping mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+func TestMarshalLspPing(t *testing.T) {
+	for _, e := range lspPingTests {
+		if got := e.Packet.Marshal(); !bytes.Equal(e.Data, got) {
+			t.Errorf("LSP Ping mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Data, got)
+		}
+	}
+}
+
+func TestLspPingReply(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("192.0.2.0/24")
+	if fec := LdpPrefixFEC(prefix); !reflect.DeepEqual(fec, lspPingTests[0].Packet.FECStack[0]) {
+		t.Errorf("FEC mismatch, got %#v", fec)
+	}
+
+	req := lspPingTests[0].Packet
+	reply := req.Reply(0x9abcdef0)
+	reply.TimestampReceived = lspPingTests[1].Packet.TimestampReceived
+	if !reflect.DeepEqual(reply, &lspPingTests[1].Packet) {
+		t.Errorf("Reply mismatch, expected %#v, got %#v", lspPingTests[1].Packet, reply)
+	}
+}
+
+func TestDecodeInvalidLspPing(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Modify func(b []byte) []byte
+		Err    error
+	}{
+		{"Truncated header", func(b []byte) []byte { return b[:31] }, ErrPacketTooShort},
+		{"Version", func(b []byte) []byte { b[1] = 2; return b }, ErrInvalidVersion},
+		{"Truncated TLV", func(b []byte) []byte { return b[:len(b)-4] }, ErrInvalidLength},
+		{"BFD Discriminator length", func(b []byte) []byte { return append(b, 0, 15, 0, 2, 0, 0, 0, 0) }, ErrInvalidLength},
+	}
+
+	for _, e := range tests {
+		_, err := DecodeLspPing(e.Modify(append([]byte{}, lspPingTests[0].Data...)))
+		if !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+	}
+}
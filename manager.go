@@ -0,0 +1,438 @@
+package bfd
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ * Identifies a session before the peer has learned our discriminator
+ */
+type sessionKey struct {
+	peer    string
+	local   string
+	ifIndex int
+	vni     uint32
+	discr   uint32 // Head discriminator of multipoint tails, ingress one of LSP egresses
+	fec     string // Target FEC Stack of LSP ingresses
+}
+
+/*
+ * A session key matches a received key when all fields agree, with a
+ * zero interface in the session matching any interface
+ */
+func (k sessionKey) matches(r sessionKey) bool {
+	return k.peer == r.peer && k.local == r.local && k.vni == r.vni && k.discr == r.discr && k.fec == r.fec && (k.ifIndex == 0 || k.ifIndex == r.ifIndex)
+}
+
+/*
+ * Maps received packets to sessions, by Your Discriminator when it is
+ * set and by session key otherwise (RFC 5880 section 6.3). Multipoint
+ * packets go to the tail keyed by their source and My Discriminator.
+ */
+type demux struct {
+	byDiscr map[uint32]*Session
+	byKey   map[sessionKey]*Session
+	keys    map[*Session]sessionKey
+}
+
+func newDemux() *demux {
+	return &demux{
+		byDiscr: make(map[uint32]*Session),
+		byKey:   make(map[sessionKey]*Session),
+		keys:    make(map[*Session]sessionKey),
+	}
+}
+
+func (d *demux) add(key sessionKey, s *Session) error {
+	discr := s.Status().LocalDiscr
+	if discr == 0 {
+		return ErrZeroMyDiscriminator
+	}
+	if _, ok := d.byDiscr[discr]; ok {
+		return ErrDuplicateSession
+	}
+	if _, ok := d.byKey[key]; ok {
+		return ErrDuplicateSession
+	}
+
+	d.byDiscr[discr] = s
+	d.byKey[key] = s
+	d.keys[s] = key
+	return nil
+}
+
+func (d *demux) remove(s *Session) {
+	key, ok := d.keys[s]
+	if !ok {
+		return
+	}
+
+	delete(d.byDiscr, s.Status().LocalDiscr)
+	delete(d.byKey, key)
+	delete(d.keys, s)
+}
+
+func (d *demux) lookup(key sessionKey, p *BfdControlPacket) (*Session, error) {
+	if p.Multipoint {
+		key.discr = p.MyDiscriminator
+	}
+	if p.YourDiscriminator == 0 {
+		if s, ok := d.byKey[key]; ok {
+			return s, nil
+		}
+		wildcard := key
+		wildcard.ifIndex = 0
+		if s, ok := d.byKey[wildcard]; ok {
+			return s, nil
+		}
+		return nil, ErrNoSession
+	}
+
+	s, ok := d.byDiscr[p.YourDiscriminator]
+	if !ok {
+		return nil, ErrUnknownDiscriminator
+	}
+	if !d.keys[s].matches(key) {
+		return nil, ErrAddressMismatch
+	}
+	return s, nil
+}
+
+/*
+ * Transmits the control packets of one session
+ */
+type sessionSender interface {
+	Transmit(p *BfdControlPacket) error
+	Close() error
+}
+
+/*
+ * Owns a set of sessions sharing a transport: allocates their local
+ * discriminators and routes received packets to them
+ */
+type Manager struct {
+	transport *Transport
+	echo      *Transport
+	lspReturn *Transport // Control packets from LSP egresses to their ingresses
+	multihop  bool
+	micro     bool
+	overlay   bool   // Sessions are identified by peer and VNI
+	vap       bool   // Overlay sessions are also identified by local address
+	loopback  bool   // Unaffiliated echo sessions, talking to themselves
+	mpls      bool   // Sessions on LSPs, bootstrapped with LSP Ping
+	vni       uint32 // VNI of sessions created by AddSession
+	dial      func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error)
+	dialVNI   func(local net.IP, peer net.IP, vni uint32) (sessionSender, error)
+	dialLsp   func(lsp Lsp) (lspSender, error)
+	afterFunc func(d time.Duration, f func()) *time.Timer // Teardown timers of unsolicited sessions
+
+	mu          sync.Mutex
+	demux       *demux
+	senders     map[*Session]sessionSender
+	echoSenders map[*Session]*EchoSender
+	drops       map[error]uint64
+	templates   map[int]UnsolicitedTemplate
+	unsolicited map[*Session]*unsolicitedSession
+	egresses    map[*Session]sessionKey // Ingress address and FEC of LSP egress sessions
+}
+
+func newManager(multihop bool) *Manager {
+	m := &Manager{
+		multihop:    multihop,
+		demux:       newDemux(),
+		senders:     make(map[*Session]sessionSender),
+		echoSenders: make(map[*Session]*EchoSender),
+		drops:       make(map[error]uint64),
+		templates:   make(map[int]UnsolicitedTemplate),
+		unsolicited: make(map[*Session]*unsolicitedSession),
+		egresses:    make(map[*Session]sessionKey),
+	}
+	m.dial = m.dialUDP
+	m.afterFunc = time.AfterFunc
+
+	return m
+}
+
+func (m *Manager) dialUDP(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+	return m.transport.Dial(local, peer)
+}
+
+/*
+ * Create a manager for single hop sessions, identified by peer address
+ * and interface
+ */
+func NewManager() *Manager {
+	m := newManager(false)
+	m.transport = NewTransport(m.handle)
+	m.transport.Drop = m.countDrop
+	m.echo = NewEchoTransport(m.handleEcho)
+	m.echo.Drop = m.countDrop
+
+	return m
+}
+
+/*
+ * Transport used by the sessions, to adjust the port before calling
+ * Listen. Its Drop handler feeds the counters returned by Drops.
+ */
+func (m *Manager) Transport() *Transport {
+	return m.transport
+}
+
+func (m *Manager) Listen() error {
+	if err := m.transport.Listen(); err != nil {
+		return err
+	}
+	if m.lspReturn != nil {
+		if err := m.lspReturn.Listen(); err != nil {
+			m.transport.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Stop listening and shut down all sessions
+ */
+func (m *Manager) Close() error {
+	err := m.transport.Close()
+	if m.echo != nil {
+		m.echo.Close()
+	}
+	if m.lspReturn != nil {
+		m.lspReturn.Close()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for s, sender := range m.echoSenders {
+		s.StopEcho()
+		sender.Close()
+	}
+	m.echoSenders = make(map[*Session]*EchoSender)
+
+	for s, sender := range m.senders {
+		s.Stop()
+		sender.Close()
+		m.demux.remove(s)
+	}
+	m.senders = make(map[*Session]sessionSender)
+
+	for _, u := range m.unsolicited {
+		u.timer.Stop()
+	}
+	m.unsolicited = make(map[*Session]*unsolicitedSession)
+	m.egresses = make(map[*Session]sessionKey)
+
+	return err
+}
+
+/*
+ * Create and start a session towards peer
+ *
+ * A unique LocalDiscr is allocated, overriding the one in status. An
+ * ifIndex of zero accepts packets from the peer on any interface.
+ * Multihop and unaffiliated echo sessions ignore ifIndex and need a
+ * local address, micro-BFD sessions need both. Overlay sessions run on
+ * the management VNI. Sessions on LSPs are created with AddLspSession.
+ */
+func (m *Manager) AddSession(peer net.IP, local net.IP, ifIndex int, status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	if m.overlay {
+		return m.AddVNISession(peer, local, m.vni, status, fn)
+	}
+	if m.mpls {
+		return nil, ErrSessionUnsupported
+	}
+	if (m.multihop || m.micro || m.loopback) && (local == nil || local.IsUnspecified()) {
+		return nil, ErrNoLocalAddress
+	}
+	if m.micro && ifIndex == 0 {
+		return nil, ErrNoInterface
+	}
+	if m.loopback {
+		// Nobody would answer a Poll for a change of mode
+		status.DemandMode = false
+	}
+
+	dial := func() (sessionSender, error) {
+		return m.dial(local, peer, ifIndex)
+	}
+	return m.addSession(m.key(peer, local, ifIndex, 0), dial, status, fn)
+}
+
+func (m *Manager) addSession(key sessionKey, dial func() (sessionSender, error), status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status.LocalDiscr = m.allocateDiscriminator()
+	return m.startSession(key, dial, NewSession(status, fn))
+}
+
+/*
+ * Register a new session and start it, with m.mu held
+ */
+func (m *Manager) startSession(key sessionKey, dial func() (sessionSender, error), s *Session) (*Session, error) {
+	if err := m.demux.add(key, s); err != nil {
+		return nil, err
+	}
+
+	sender, err := dial()
+	if err != nil {
+		m.demux.remove(s)
+		return nil, err
+	}
+	m.senders[s] = sender
+
+	s.Start(sender.Transmit)
+	return s, nil
+}
+
+/*
+ * Pick a random unused non-zero discriminator
+ */
+func (m *Manager) allocateDiscriminator() uint32 {
+	for {
+		discr := rand.Uint32()
+		if _, ok := m.demux.byDiscr[discr]; discr != 0 && !ok {
+			return discr
+		}
+	}
+}
+
+/*
+ * Stop a session and forget about it
+ */
+func (m *Manager) RemoveSession(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sender, ok := m.senders[s]
+	if !ok {
+		return
+	}
+
+	s.Stop()
+	sender.Close()
+	m.demux.remove(s)
+	delete(m.senders, s)
+
+	if sender, ok := m.echoSenders[s]; ok {
+		sender.Close()
+		delete(m.echoSenders, s)
+	}
+	if u, ok := m.unsolicited[s]; ok {
+		u.timer.Stop()
+		delete(m.unsolicited, s)
+	}
+	delete(m.egresses, s)
+}
+
+/*
+ * Look up a session by its local discriminator
+ */
+func (m *Manager) Session(discr uint32) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.demux.byDiscr[discr]
+}
+
+/*
+ * All sessions owned by the manager
+ */
+func (m *Manager) Sessions() []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(m.senders))
+	for s := range m.senders {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+/*
+ * Deliver a received packet to its session, creating one if the
+ * receiving interface has unsolicited BFD enabled
+ *
+ * Packets that cannot be delivered are counted under the reason
+ * returned.
+ */
+func (m *Manager) Receive(r *ReceivedPacket) error {
+	key := m.key(r.Peer.IP, r.Local, r.IfIndex, r.VNI)
+
+	m.mu.Lock()
+	var s *Session
+	var err error
+	switch {
+	case m.loopback:
+		s, err = m.demux.lookupLoopback(r.Peer.IP, r.Packet)
+	case m.mpls:
+		s, err = m.demux.lookupLsp(r.Peer.IP, r.Packet)
+	default:
+		s, err = m.demux.lookup(key, r.Packet)
+	}
+	m.mu.Unlock()
+
+	if errors.Is(err, ErrNoSession) {
+		return m.receiveUnsolicited(key, r)
+	}
+	if err == nil {
+		err = s.Receive(r.Packet)
+	}
+	if err != nil {
+		m.countDrop(err, r.Peer)
+	}
+	return err
+}
+
+func (m *Manager) handle(r *ReceivedPacket) {
+	m.Receive(r)
+}
+
+/*
+ * Number of discarded packets, by reason
+ *
+ * The keys are the Err* values of this package, so DecodeError details
+ * and the socket errors behind ErrReceiveFailed are folded into their
+ * underlying reason.
+ */
+func (m *Manager) Drops() map[error]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	drops := make(map[error]uint64, len(m.drops))
+	for reason, n := range m.drops {
+		drops[reason] = n
+	}
+	return drops
+}
+
+func (m *Manager) countDrop(err error, peer *net.UDPAddr) {
+	m.mu.Lock()
+	m.drops[dropReason(err)]++
+	m.mu.Unlock()
+}
+
+/*
+ * Single hop sessions are identified by peer and interface, multihop
+ * and unaffiliated echo sessions by their address pair and overlay
+ * sessions by peer (and for Geneve local) address and VNI
+ */
+func (m *Manager) key(peer net.IP, local net.IP, ifIndex int, vni uint32) sessionKey {
+	switch {
+	case m.overlay && m.vap:
+		return sessionKey{peer: peer.String(), local: local.String(), vni: vni}
+	case m.overlay:
+		return sessionKey{peer: peer.String(), vni: vni}
+	case m.multihop || m.loopback:
+		return sessionKey{peer: peer.String(), local: local.String()}
+	}
+	return sessionKey{peer: peer.String(), ifIndex: ifIndex}
+}
@@ -0,0 +1,304 @@
+//go:build linux
+
+package bfd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDemuxLookup(t *testing.T) {
+	d := newDemux()
+
+	status := BfdStatusDefaults
+	status.LocalDiscr = 7
+	s := NewSession(status, nil)
+	key := sessionKey{peer: "10.0.0.2", ifIndex: 3}
+	other := sessionKey{peer: "10.0.0.3", ifIndex: 3}
+
+	if err := d.add(key, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.add(key, NewSession(status, nil)); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("Duplicate session accepted, got %v", err)
+	}
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 9
+
+	if got, err := d.lookup(key, &p); got != s || err != nil {
+		t.Errorf("Lookup by address pair failed: %v", err)
+	}
+	if _, err := d.lookup(other, &p); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Lookup by unknown address pair, got %v", err)
+	}
+
+	p.YourDiscriminator = 7
+	if got, err := d.lookup(key, &p); got != s || err != nil {
+		t.Errorf("Lookup by discriminator failed: %v", err)
+	}
+	if _, err := d.lookup(other, &p); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Lookup by discriminator from wrong address, got %v", err)
+	}
+
+	p.YourDiscriminator = 8
+	if _, err := d.lookup(key, &p); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Lookup by unknown discriminator, got %v", err)
+	}
+
+	d.remove(s)
+	p.YourDiscriminator = 7
+	if _, err := d.lookup(key, &p); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Lookup after remove, got %v", err)
+	}
+}
+
+func TestDemuxAnyInterface(t *testing.T) {
+	d := newDemux()
+
+	status := BfdStatusDefaults
+	status.LocalDiscr = 7
+	s := NewSession(status, nil)
+	d.add(sessionKey{peer: "10.0.0.2"}, s)
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 9
+
+	if got, _ := d.lookup(sessionKey{peer: "10.0.0.2", ifIndex: 4}, &p); got != s {
+		t.Errorf("Session without interface not found by address")
+	}
+
+	p.YourDiscriminator = 7
+	if got, _ := d.lookup(sessionKey{peer: "10.0.0.2", ifIndex: 4}, &p); got != s {
+		t.Errorf("Session without interface not found by discriminator")
+	}
+}
+
+func TestManagerReceive(t *testing.T) {
+	m := NewManager()
+	m.Transport().Port = 9 // Discard, nothing is listening
+	defer m.Close()
+
+	peer := net.ParseIP("127.0.0.1")
+	a, err := m.AddSession(peer, nil, 1, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.AddSession(peer, nil, 2, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddSession(peer, nil, 2, BfdStatusDefaults, nil); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("Duplicate session accepted, got %v", err)
+	}
+
+	discrA := a.Status().LocalDiscr
+	discrB := b.Status().LocalDiscr
+	if discrA == 0 || discrB == 0 || discrA == discrB {
+		t.Fatalf("Bad discriminators allocated: %d, %d", discrA, discrB)
+	}
+	if m.Session(discrA) != a || len(m.Sessions()) != 2 {
+		t.Errorf("Sessions not indexed by discriminator")
+	}
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 100
+	r := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: peer, Port: 49152}, IfIndex: 2}
+
+	// Initial packet found by peer and interface
+	if err := m.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+	if a.State() != STATE_DOWN || b.State() != STATE_INIT {
+		t.Errorf("Packet delivered to the wrong session")
+	}
+
+	// Then by discriminator
+	p.State = STATE_INIT
+	p.YourDiscriminator = discrB
+	if err := m.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != STATE_UP {
+		t.Errorf("Packet not delivered by discriminator")
+	}
+
+	p.YourDiscriminator = discrA ^ discrB
+	if err := m.Receive(r); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Unknown discriminator accepted, got %v", err)
+	}
+	r.IfIndex = 3
+	p.YourDiscriminator = 0
+	p.State = STATE_DOWN
+	if err := m.Receive(r); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Unknown interface accepted, got %v", err)
+	}
+
+	// Authentication failures are counted separately
+	r.IfIndex = 2
+	b.SetAuthentication(BFD_AUTH_TYPE_SIMPLE, 1, []byte("password"))
+	if err := m.Receive(r); !errors.Is(err, ErrAuthMismatch) {
+		t.Errorf("Unauthenticated packet accepted, got %v", err)
+	}
+	p.AuthPresent = true
+	p.AuthHeader = &BfdAuthHeader{Type: BFD_AUTH_TYPE_SIMPLE, AuthKeyID: 1, AuthData: []byte("wrong")}
+	if err := m.Receive(r); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Wrong password accepted, got %v", err)
+	}
+
+	drops := m.Drops()
+	if drops[ErrUnknownDiscriminator] != 1 || drops[ErrNoSession] != 1 || drops[ErrAuthMismatch] != 1 || drops[ErrPasswordMismatch] != 1 {
+		t.Errorf("Drop counters mismatch, got %v", drops)
+	}
+
+	m.RemoveSession(a)
+	if m.Session(discrA) != nil || len(m.Sessions()) != 1 {
+		t.Errorf("Session not removed")
+	}
+}
+
+func TestDropReceiveErrors(t *testing.T) {
+	m := NewManager()
+	defer m.Close()
+
+	for _, err := range []error{syscall.ECONNREFUSED, syscall.ENOBUFS} {
+		m.Transport().drop(fmt.Errorf("%w: %v", ErrReceiveFailed, err), nil)
+	}
+	if drops := m.Drops(); drops[ErrReceiveFailed] != 2 || len(drops) != 1 {
+		t.Errorf("Receive errors not counted together, got %v", drops)
+	}
+}
+
+func TestUnsolicited(t *testing.T) {
+	m := NewManager()
+	m.Transport().Port = 9 // Discard, nothing is listening
+	defer m.Close()
+
+	// Expire the teardown timer at will
+	var expire func()
+	m.afterFunc = func(d time.Duration, f func()) *time.Timer {
+		if d != 50*time.Millisecond {
+			t.Errorf("Timeout mismatch, expected 50ms, got %v", d)
+		}
+		expire = f
+		return time.AfterFunc(time.Hour, func() {})
+	}
+
+	var changes []BfdState
+	tmpl := UnsolicitedTemplate{
+		Status:      BfdStatusDefaults,
+		MaxSessions: 1,
+		Timeout:     50000,
+		OnChange: func(s *Session, old BfdState, new BfdState) {
+			changes = append(changes, new)
+		},
+	}
+	if err := m.EnableUnsolicited(2, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 100
+	r := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 49152}, IfIndex: 3}
+
+	if err := m.Receive(r); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Session created on a disabled interface, got %v", err)
+	}
+
+	r.IfIndex = 2
+	if err := m.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+	sessions := m.Sessions()
+	if len(sessions) != 1 || !m.Unsolicited(sessions[0]) || sessions[0].State() != STATE_INIT {
+		t.Fatalf("Passive session not created")
+	}
+	s := sessions[0]
+
+	other := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 49152}, IfIndex: 2}
+	if err := m.Receive(other); !errors.Is(err, ErrUnsolicitedLimit) {
+		t.Errorf("Session limit not enforced, got %v", err)
+	}
+
+	// Up sessions are kept
+	p.State = STATE_UP
+	p.YourDiscriminator = s.Status().LocalDiscr
+	if err := m.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+	expire()
+	if s.State() != STATE_UP || m.Session(s.Status().LocalDiscr) != s {
+		t.Errorf("Up session removed")
+	}
+
+	// Down ones go away after the timeout
+	p.State = STATE_ADMIN_DOWN
+	m.Receive(r)
+	expire()
+	if len(m.Sessions()) != 0 {
+		t.Errorf("Down session not removed")
+	}
+	expected := []BfdState{STATE_INIT, STATE_UP, STATE_DOWN}
+	if len(changes) != len(expected) || changes[0] != expected[0] || changes[1] != expected[1] || changes[2] != expected[2] {
+		t.Errorf("State changes mismatch, expected %v, got %v", expected, changes)
+	}
+
+	// Only Down packets start a session
+	p.YourDiscriminator = 0
+	if err := m.Receive(other); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Session created from an AdminDown packet, got %v", err)
+	}
+	p.State = STATE_DOWN
+	if err := m.Receive(other); err != nil {
+		t.Fatal(err)
+	}
+
+	m.DisableUnsolicited(2)
+	if len(m.Sessions()) != 0 {
+		t.Errorf("Sessions not removed with their template")
+	}
+	if err := m.Receive(r); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Session created after disabling, got %v", err)
+	}
+}
+
+/*
+ * Peers failing authentication do not get to keep a session
+ */
+func TestUnsolicitedAuthentication(t *testing.T) {
+	m := NewManager()
+	m.Transport().Port = 9 // Discard, nothing is listening
+	defer m.Close()
+
+	kc := NewKeyChain()
+	kc.Add(Key{ID: 1, Type: BFD_AUTH_TYPE_SIMPLE, Secret: []byte("password")})
+	m.EnableUnsolicited(0, UnsolicitedTemplate{Status: BfdStatusDefaults, KeyChain: kc})
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 100
+	r := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 49152}, IfIndex: 5}
+
+	if err := m.Receive(r); !errors.Is(err, ErrAuthMismatch) {
+		t.Errorf("Unauthenticated packet accepted, got %v", err)
+	}
+	if len(m.Sessions()) != 0 {
+		t.Errorf("Session kept for an unauthenticated peer")
+	}
+
+	p.AuthPresent = true
+	p.AuthHeader = &BfdAuthHeader{Type: BFD_AUTH_TYPE_SIMPLE, AuthKeyID: 1, AuthData: []byte("password")}
+	if err := m.Receive(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sessions()) != 1 {
+		t.Errorf("Session not created for an authenticated peer")
+	}
+
+	if err := NewMultihopManager(BFD_TTL).EnableUnsolicited(0, UnsolicitedTemplate{}); !errors.Is(err, ErrUnsolicitedUnsupported) {
+		t.Errorf("Unsolicited BFD enabled for multihop sessions, got %v", err)
+	}
+}
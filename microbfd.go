@@ -0,0 +1,278 @@
+package bfd
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const BFD_PORT_MICRO = 6784 // Micro-BFD on LAG member links, RFC 7130
+
+/*
+ * Dedicated destination MAC address of micro-BFD packets (RFC 7130
+ * section 2.1)
+ */
+var BFD_MICRO_MAC = net.HardwareAddr{0x01, 0x00, 0x5e, 0x90, 0x00, 0x01}
+
+/*
+ * Create a transport for micro-BFD control packets, which are single
+ * hop and so only accepted with a TTL / Hop Limit of 255
+ */
+func NewMicroTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_MICRO,
+		MinTTL:  BFD_TTL,
+		Handler: handler,
+	}
+}
+
+/*
+ * Create a manager for micro-BFD sessions, one per LAG member link,
+ * identified by peer address and member interface
+ *
+ * Packets are sent directly on the member link to BFD_MICRO_MAC rather
+ * than routed over the aggregate, which also makes the link accept
+ * packets sent to that address. They are received on the member link
+ * as well, as the system reports packets arriving on any member of a
+ * LAG on the aggregate interface: Listen only holds port 6784, so that
+ * the packets are not answered with ICMP errors.
+ */
+func NewMicroManager() *Manager {
+	m := newManager(false)
+	m.micro = true
+	m.transport = NewMicroTransport(nil)
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		s, err := DialMicro(ifIndex, local, peer)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Listen(m.handle, m.countDrop)
+		return s, nil
+	}
+
+	return m
+}
+
+/*
+ * Transmits and receives micro-BFD control packets on a single member
+ * link
+ */
+type MicroSender struct {
+	file    *os.File // Packet socket bound to the member link
+	ifIndex int
+	local   net.IP
+	peer    net.IP
+	srcPort uint16
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newMicroSender(file *os.File, ifIndex int, local net.IP, peer net.IP) *MicroSender {
+	span := BFD_SOURCE_PORT_MAX - BFD_SOURCE_PORT_MIN + 1
+	return &MicroSender{
+		file:    file,
+		ifIndex: ifIndex,
+		local:   local,
+		peer:    peer,
+		srcPort: uint16(BFD_SOURCE_PORT_MIN + rand.Intn(span)),
+		done:    make(chan struct{}),
+	}
+}
+
+/*
+ * Send a control packet, usable as a TransmitFunc
+ */
+func (s *MicroSender) Transmit(p *BfdControlPacket) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.send(encapsulateUDP(s.local, s.peer, s.srcPort, BFD_PORT_MICRO, b))
+}
+
+/*
+ * Start handing the control packets received on the member link to
+ * handler, and those discarded to drop unless nil, until Close
+ *
+ * Like on single hop transports, packets must arrive with a TTL / Hop
+ * Limit of 255.
+ */
+func (s *MicroSender) Listen(handler PacketHandler, drop DropHandler) {
+	if drop == nil {
+		drop = func(err error, peer *net.UDPAddr) {}
+	}
+
+	s.wg.Add(1)
+	go s.receiveLoop(handler, drop)
+}
+
+func (s *MicroSender) receiveLoop(handler PacketHandler, drop DropHandler) {
+	defer s.wg.Done()
+
+	buf := make([]byte, 1500)
+	backoff := time.Duration(0)
+
+	for {
+		n, ok, err := s.recv(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			drop(fmt.Errorf("%w: %v", ErrReceiveFailed, err), nil)
+			backoff = readBackoff(backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		if !ok {
+			continue
+		}
+
+		d, err := decapsulateUDP(buf[:n])
+		if err != nil {
+			drop(err, nil)
+			continue
+		}
+		if d.DstPort != BFD_PORT_MICRO {
+			continue
+		}
+
+		peer := &net.UDPAddr{IP: d.Src, Port: int(d.SrcPort)}
+		if d.TTL < BFD_TTL {
+			drop(ErrInvalidTTL, peer)
+			continue
+		}
+
+		p, err := Decode(d.Payload)
+		if err != nil {
+			drop(err, peer)
+			continue
+		}
+
+		handler(&ReceivedPacket{Packet: p, Peer: peer, Local: d.Dst, IfIndex: s.ifIndex, TTL: d.TTL})
+	}
+}
+
+/*
+ * Close the socket, and wait for the receive loop to finish
+ */
+func (s *MicroSender) Close() error {
+	close(s.done)
+	err := s.file.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+/*
+ * The micro-BFD sessions of one LAG, one per member link
+ */
+type Bundle struct {
+	manager *Manager
+	local   net.IP
+	peer    net.IP
+	status  BfdStatus
+	fn      StateChangeFunc
+
+	mu      sync.Mutex
+	members map[int]*Session
+}
+
+/*
+ * Create a bundle whose member sessions run between the local and peer
+ * addresses of the LAG, starting from status and reporting state
+ * changes to fn
+ */
+func (m *Manager) NewBundle(local net.IP, peer net.IP, status BfdStatus, fn StateChangeFunc) *Bundle {
+	return &Bundle{
+		manager: m,
+		local:   local,
+		peer:    peer,
+		status:  status,
+		fn:      fn,
+		members: make(map[int]*Session),
+	}
+}
+
+/*
+ * Start a session on the member link with the given interface index
+ */
+func (b *Bundle) AddMember(ifIndex int) (*Session, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.members[ifIndex]; ok {
+		return nil, ErrDuplicateSession
+	}
+
+	s, err := b.manager.AddSession(b.peer, b.local, ifIndex, b.status, b.fn)
+	if err != nil {
+		return nil, err
+	}
+
+	b.members[ifIndex] = s
+	return s, nil
+}
+
+/*
+ * Stop the session of a member link leaving the bundle
+ */
+func (b *Bundle) RemoveMember(ifIndex int) {
+	b.mu.Lock()
+	s, ok := b.members[ifIndex]
+	delete(b.members, ifIndex)
+	b.mu.Unlock()
+
+	if ok {
+		b.manager.RemoveSession(s)
+	}
+}
+
+/*
+ * Session of a member link, nil if it is not part of the bundle
+ */
+func (b *Bundle) Member(ifIndex int) *Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.members[ifIndex]
+}
+
+/*
+ * Session state of every member link, by interface index
+ */
+func (b *Bundle) Members() map[int]BfdState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make(map[int]BfdState, len(b.members))
+	for ifIndex, s := range b.members {
+		states[ifIndex] = s.State()
+	}
+	return states
+}
+
+/*
+ * Interface indexes of the member links whose session is Up, in
+ * ascending order; only these should carry traffic (RFC 7130 section 3)
+ */
+func (b *Bundle) UpMembers() []int {
+	var up []int
+	for ifIndex, state := range b.Members() {
+		if state == STATE_UP {
+			up = append(up, ifIndex)
+		}
+	}
+	sort.Ints(up)
+
+	return up
+}
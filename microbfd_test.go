@@ -0,0 +1,169 @@
+//go:build linux
+
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMicroEncapsulation(t *testing.T) {
+	local := net.ParseIP("2001:db8::1")
+	peer := net.ParseIP("2001:db8::2")
+	s := newMicroSender(nil, 3, local, peer)
+	payload := []byte{1, 2, 3}
+	b := encapsulateUDP(s.local, s.peer, s.srcPort, BFD_PORT_MICRO, payload)
+
+	if b[0]>>4 != 6 || b[6] != 17 || b[7] != BFD_TTL {
+		t.Errorf("Bad version, next header or hop limit: %d, %d, %d", b[0]>>4, b[6], b[7])
+	}
+	if !net.IP(b[8:24]).Equal(local) || !net.IP(b[24:40]).Equal(peer) {
+		t.Errorf("Bad addresses")
+	}
+	if binary.BigEndian.Uint16(b[42:44]) != BFD_PORT_MICRO || binary.BigEndian.Uint16(b[4:6]) != 11 {
+		t.Errorf("Bad destination port or payload length")
+	}
+
+	pseudo := uint32(17) + uint32(len(b)-40) + addressSum(b[8:40])
+	if checksum(b[40:], pseudo) != 0 {
+		t.Errorf("Bad UDP checksum")
+	}
+}
+
+type linkSender func(p *BfdControlPacket) error
+
+func (l linkSender) Transmit(p *BfdControlPacket) error {
+	return l(p)
+}
+
+func (l linkSender) Close() error {
+	return nil
+}
+
+/*
+ * Two bundles joined by member links 3 and 4, which can be cut
+ */
+func TestBundle(t *testing.T) {
+	addrA := net.ParseIP("10.0.0.1")
+	addrB := net.ParseIP("10.0.0.2")
+
+	var mu sync.Mutex
+	cut := make(map[int]bool)
+
+	ma := NewMicroManager()
+	mb := NewMicroManager()
+	link := func(to *Manager, from net.IP) func(net.IP, net.IP, int) (sessionSender, error) {
+		return func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+			return linkSender(func(p *BfdControlPacket) error {
+				mu.Lock()
+				down := cut[ifIndex]
+				mu.Unlock()
+				if !down {
+					go to.Receive(&ReceivedPacket{Packet: p, Peer: &net.UDPAddr{IP: from}, IfIndex: ifIndex})
+				}
+				return nil
+			}), nil
+		}
+	}
+	ma.dial = link(mb, addrA)
+	mb.dial = link(ma, addrB)
+	defer ma.Close()
+	defer mb.Close()
+
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+	ba := ma.NewBundle(addrA, addrB, status, nil)
+	bb := mb.NewBundle(addrB, addrA, status, nil)
+
+	for _, ifIndex := range []int{3, 4} {
+		if _, err := ba.AddMember(ifIndex); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bb.AddMember(ifIndex); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ba.AddMember(3); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("Duplicate member accepted, got %v", err)
+	}
+	if _, err := ba.AddMember(0); !errors.Is(err, ErrNoInterface) {
+		t.Errorf("Member without interface accepted, got %v", err)
+	}
+
+	waitMembers := func(b *Bundle, expected []int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !reflect.DeepEqual(b.UpMembers(), expected) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected members %v Up, got %v", expected, b.Members())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitMembers(ba, []int{3, 4})
+	waitMembers(bb, []int{3, 4})
+
+	mu.Lock()
+	cut[4] = true
+	mu.Unlock()
+	waitMembers(ba, []int{3})
+	waitMembers(bb, []int{3})
+	if diag := ba.Member(4).Status().LocalDiag; diag != DIAG_TIME_EXPIRED {
+		t.Errorf("Diagnostic mismatch, got %v", diag)
+	}
+
+	ba.RemoveMember(4)
+	if ba.Member(4) != nil || len(ba.Members()) != 1 {
+		t.Errorf("Member not removed")
+	}
+}
+
+/*
+ * A packet sent on a link reaches the session of that link through its
+ * packet socket, with no help from the UDP transport
+ */
+func TestMicroReceive(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := net.IPv4(127, 0, 0, 1)
+	peer := net.IPv4(127, 0, 0, 2)
+
+	sender, err := DialMicro(lo.Index, peer, local)
+	if err != nil {
+		t.Skipf("Cannot open packet socket: %v", err)
+	}
+	defer sender.Close()
+
+	m := NewMicroManager()
+	defer m.Close()
+	s, err := m.AddSession(peer, local, lo.Index, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &BfdControlPacket{
+		Version:               1,
+		State:                 STATE_DOWN,
+		DetectMult:            3,
+		MyDiscriminator:       42,
+		DesiredMinTxInterval:  1000000,
+		RequiredMinRxInterval: 1000000,
+	}
+	if err := sender.Transmit(p); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, s, STATE_INIT)
+	if discr := s.Status().RemoteDiscr; discr != 42 {
+		t.Errorf("Remote discriminator mismatch, expected 42, got %d", discr)
+	}
+}
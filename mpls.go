@@ -0,0 +1,386 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+)
+
+/*
+ * A packet sent along an LSP (RFC 5884 section 7)
+ *
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                Label Stack, bottom entry with S set           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |            IP and UDP headers, destination in 127/8           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |             BFD Control Packet or MPLS Echo Request           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The IP TTL / Hop Limit of 1 and the loopback destination keep the
+ * packet from being forwarded past the egress should the LSP break.
+ */
+type MplsPacket struct {
+	Labels      []uint32 // Label stack, outermost first
+	Src         net.IP   // Routable address of the ingress
+	Dst         net.IP   // 127/8, or ::ffff:127.0.0.0/104 for IPv6, random if nil
+	SrcPort     uint16
+	DstPort     uint16 // BFD_PORT_SINGLE_HOP or LSP_PING_PORT
+	TTL         int    // IP TTL / Hop Limit, 1 when sent
+	RouterAlert bool   // Set on MPLS Echo Requests
+	Payload     []byte
+}
+
+/*
+ * Random loopback destination for packets from src, so that transit
+ * routers hashing on addresses spread LSPs over their paths
+ */
+func mplsDestination(src net.IP) net.IP {
+	dst := net.IPv4(127, byte(rand.Intn(256)), byte(rand.Intn(256)), byte(1+rand.Intn(254)))
+	if src.To4() != nil {
+		return dst.To4()
+	}
+	return dst
+}
+
+/*
+ * Marshal the label stack and everything it carries
+ */
+func (m *MplsPacket) Marshal() []byte {
+	dst := m.Dst
+	if dst == nil {
+		dst = mplsDestination(m.Src)
+	}
+
+	b := make([]byte, 4*len(m.Labels))
+	for i, label := range m.Labels {
+		entry := label<<12 | 255 // Traffic Class 0, TTL 255
+		if i == len(m.Labels)-1 {
+			entry |= 0x100 // Bottom of Stack
+		}
+		binary.BigEndian.PutUint32(b[4*i:], entry)
+	}
+
+	return append(b, encapsulateIP(m.Src, dst, m.SrcPort, m.DstPort, 1, m.RouterAlert, m.Payload)...)
+}
+
+/*
+ * Decode a labeled packet as received by the egress of an LSP
+ *
+ * The packet inside must be UDP addressed to a loopback destination.
+ * Its payload is left for Decode or DecodeLspPing, depending on the
+ * destination port.
+ */
+func DecodeMpls(data []byte) (*MplsPacket, error) {
+	m := &MplsPacket{}
+
+	offset := 0
+	for {
+		if len(data)-offset < 4 {
+			return nil, decodeError("Label Stack", offset, ErrPacketTooShort)
+		}
+		entry := binary.BigEndian.Uint32(data[offset : offset+4])
+		m.Labels = append(m.Labels, entry>>12)
+		offset += 4
+		if entry&0x100 != 0 {
+			break
+		}
+	}
+
+	d, err := decapsulateUDP(data[offset:])
+	if err != nil {
+		return nil, shiftDecodeError(err, offset)
+	}
+	if !loopbackDestinationValid(d.Dst) {
+		return nil, decodeError("Destination Address", offset, ErrInvalidEncapsulation)
+	}
+
+	m.Src, m.Dst, m.TTL, m.RouterAlert = d.Src, d.Dst, d.TTL, d.RouterAlert
+	m.SrcPort, m.DstPort, m.Payload = d.SrcPort, d.DstPort, d.Payload
+	return m, nil
+}
+
+/*
+ * Create a transport for BFD on LSPs, receiving the packets sent by
+ * ingresses
+ *
+ * Packets from the ingress arrive with a TTL of 1, so the TTL is not
+ * checked.
+ */
+func NewMplsTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_SINGLE_HOP,
+		MinTTL:  0,
+		Handler: handler,
+	}
+}
+
+/*
+ * Create a manager for BFD sessions on MPLS LSPs (RFC 5884)
+ *
+ * The ingress of an LSP creates its session with AddLspSession, which
+ * announces its discriminator to the egress with an LSP Ping echo
+ * request. The egress hands the request to AcceptLspPing, and from
+ * then on sends control packets back to the ingress over IP.
+ *
+ * Packets on the LSP use port 3784 like single hop sessions, and those
+ * routed back to the ingress port 4784 like multihop sessions (RFC 5884
+ * section 7). The manager listens on both: it cannot be used alongside
+ * a Manager from NewManager or NewMultihopManager.
+ */
+func NewMplsManager() *Manager {
+	m := newManager(false)
+	m.mpls = true
+	m.transport = NewMplsTransport(m.handle)
+	m.transport.Drop = m.countDrop
+	m.lspReturn = NewMultihopTransport(0, m.handle)
+	m.lspReturn.Drop = m.countDrop
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return m.lspReturn.Dial(local, peer)
+	}
+	m.dialLsp = func(lsp Lsp) (lspSender, error) {
+		return DialLsp(lsp)
+	}
+
+	return m
+}
+
+/*
+ * Transport carrying the packets of egress sessions back to the
+ * ingresses, to adjust the port before calling Listen. Routed packets
+ * may have crossed any number of hops, so the TTL is not checked.
+ */
+func (m *Manager) LspReturnTransport() *Transport {
+	return m.lspReturn
+}
+
+/*
+ * An LSP as seen from its ingress
+ */
+type Lsp struct {
+	FEC     []LspPingTLV     // Target FEC Stack sub-TLVs identifying the LSP
+	Labels  []uint32         // Label stack pushed on packets, outermost first
+	IfIndex int              // Outgoing interface
+	NextHop net.HardwareAddr // MAC address of the next hop
+	Local   net.IP           // Routable address of the ingress
+}
+
+/*
+ * Sends on an LSP, and bootstraps sessions over it
+ */
+type lspSender interface {
+	sessionSender
+	Bootstrap(discr uint32) error
+}
+
+/*
+ * Create and start the ingress session of an LSP, and send an LSP Ping
+ * echo request carrying its discriminator to the egress
+ *
+ * Further echo requests can be sent with SendLspPing, for instance
+ * after the session went down.
+ */
+func (m *Manager) AddLspSession(lsp Lsp, status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	if !m.mpls {
+		return nil, ErrSessionUnsupported
+	}
+	if lsp.Local == nil || lsp.Local.IsUnspecified() {
+		return nil, ErrNoLocalAddress
+	}
+	if lsp.IfIndex == 0 {
+		return nil, ErrNoInterface
+	}
+
+	key := sessionKey{local: lsp.Local.String(), fec: string(marshalTLVs(lsp.FEC))}
+	dial := func() (sessionSender, error) {
+		return m.dialLsp(lsp)
+	}
+	s, err := m.addSession(key, dial, status, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.SendLspPing(s); err != nil {
+		m.RemoveSession(s)
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+ * Send an LSP Ping echo request with the discriminator of an ingress
+ * session
+ */
+func (m *Manager) SendLspPing(s *Session) error {
+	m.mu.Lock()
+	sender, ok := m.senders[s].(lspSender)
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrNoSession
+	}
+	return sender.Bootstrap(s.Status().LocalDiscr)
+}
+
+/*
+ * Create and start the egress session for an LSP Ping echo request
+ * received from the ingress at address ingress
+ *
+ * The session sends control packets to the ingress over IP, starting
+ * with its discriminator as Your Discriminator. They leave from an
+ * address picked by the system, which need not be the one the ingress
+ * sent the request to: the ingress finds its sessions by discriminator
+ * alone and does not check where their packets come from. A request for an
+ * existing session returns it again, while one for the FEC of an
+ * existing session with another discriminator, as sent by an ingress
+ * which restarted, replaces it. The echo reply announcing the local
+ * discriminator is to be sent back to the ingress unless nil, when the
+ * request asked for no reply.
+ */
+func (m *Manager) AcceptLspPing(req *LspPingPacket, ingress net.IP, status BfdStatus, fn StateChangeFunc) (*Session, *LspPingPacket, error) {
+	if !m.mpls {
+		return nil, nil, ErrSessionUnsupported
+	}
+	if req.MessageType != LSP_PING_ECHO_REQUEST {
+		return nil, nil, ErrNotEchoRequest
+	}
+	if req.BfdDiscriminator == 0 {
+		return nil, nil, ErrZeroMyDiscriminator
+	}
+
+	key := sessionKey{peer: ingress.String(), discr: req.BfdDiscriminator}
+	lsp := sessionKey{peer: ingress.String(), fec: string(marshalTLVs(req.FECStack))}
+	m.mu.Lock()
+	s, ok := m.demux.byKey[key]
+	var stale *Session
+	for e, k := range m.egresses {
+		if k == lsp && e != s {
+			stale = e
+		}
+	}
+	m.mu.Unlock()
+
+	if ok {
+		s.learnRemoteDiscr(req.BfdDiscriminator)
+	} else {
+		if stale != nil {
+			m.RemoveSession(stale)
+		}
+
+		status.RemoteDiscr = req.BfdDiscriminator
+		dial := func() (sessionSender, error) {
+			return m.dial(nil, ingress, 0)
+		}
+
+		m.mu.Lock()
+		status.LocalDiscr = m.allocateDiscriminator()
+		var err error
+		s, err = m.startSession(key, dial, NewSession(status, fn))
+		if err == nil {
+			m.egresses[s] = lsp
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if req.ReplyMode == LSP_PING_REPLY_NONE {
+		return s, nil, nil
+	}
+	return s, req.Reply(s.Status().LocalDiscr), nil
+}
+
+/*
+ * Take the remote discriminator from an LSP Ping, unless the session
+ * already knows it
+ */
+func (s *Session) learnRemoteDiscr(discr uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.RemoteDiscr == 0 {
+		s.status.RemoteDiscr = discr
+	}
+}
+
+/*
+ * The ingress only learns the address of the egress from its packets,
+ * which carry its discriminator from the start; the egress finds its
+ * sessions by ingress address and discriminator until it is known
+ *
+ * The source address is thus only checked for egress sessions: packets
+ * to an ingress always have a Your Discriminator, and come from an
+ * address the ingress has no way to verify.
+ */
+func (d *demux) lookupLsp(source net.IP, p *BfdControlPacket) (*Session, error) {
+	if p.YourDiscriminator == 0 {
+		s, ok := d.byKey[sessionKey{peer: source.String(), discr: p.MyDiscriminator}]
+		if !ok {
+			return nil, ErrNoSession
+		}
+		return s, nil
+	}
+
+	s, ok := d.byDiscr[p.YourDiscriminator]
+	if !ok {
+		return nil, ErrUnknownDiscriminator
+	}
+	if key := d.keys[s]; key.fec == "" && key.peer != source.String() {
+		return nil, ErrAddressMismatch
+	}
+	return s, nil
+}
+
+/*
+ * Transmits control packets and LSP Ping echo requests on an LSP
+ */
+type LspSender struct {
+	fd      int
+	lsp     Lsp
+	dst     net.IP
+	srcPort uint16
+}
+
+func newLspSender(fd int, lsp Lsp) *LspSender {
+	span := BFD_SOURCE_PORT_MAX - BFD_SOURCE_PORT_MIN + 1
+	return &LspSender{
+		fd:      fd,
+		lsp:     lsp,
+		dst:     mplsDestination(lsp.Local),
+		srcPort: uint16(BFD_SOURCE_PORT_MIN + rand.Intn(span)),
+	}
+}
+
+func (s *LspSender) packet(dstPort uint16, payload []byte) []byte {
+	m := &MplsPacket{
+		Labels:      s.lsp.Labels,
+		Src:         s.lsp.Local,
+		Dst:         s.dst,
+		SrcPort:     s.srcPort,
+		DstPort:     dstPort,
+		RouterAlert: dstPort == LSP_PING_PORT,
+		Payload:     payload,
+	}
+	return m.Marshal()
+}
+
+/*
+ * Send a control packet, usable as a TransmitFunc
+ */
+func (s *LspSender) Transmit(p *BfdControlPacket) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.send(s.packet(BFD_PORT_SINGLE_HOP, b))
+}
+
+/*
+ * Send an echo request for the FEC of the LSP, carrying the BFD
+ * discriminator discr
+ */
+func (s *LspSender) Bootstrap(discr uint32) error {
+	return s.send(s.packet(LSP_PING_PORT, NewLspPingRequest(s.lsp.FEC, discr).Marshal()))
+}
@@ -0,0 +1,342 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type mplsTestSet struct {
+	Name   string
+	Data   []byte
+	Packet MplsPacket
+}
+
+/*
+ * Packets along an LSP as laid out by RFC 5884 section 7, the payload
+ * being opaque to DecodeMpls
+ */
+var mplsTests = []mplsTestSet{
+	{
+		Name: "BFD over IPv4",
+		Data: []byte{
+			0x00, 0x01, 0x01, 0xff, // Label 16, Bottom of Stack, TTL 255
+			0x45, 0x00, 0x00, 0x34, // IPv4, Total Length 52
+			0x00, 0x00, 0x40, 0x00, // Don't Fragment
+			0x01, 0x11, 0x38, 0xb7, // TTL 1, UDP, Header Checksum
+			0xc0, 0x00, 0x02, 0x01, // Source 192.0.2.1
+			0x7f, 0x00, 0x00, 0x01, // Destination 127.0.0.1
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0x47, 0xd2, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 25
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: MplsPacket{
+			Labels:  []uint32{16},
+			Src:     net.IP{192, 0, 2, 1},
+			Dst:     net.IP{127, 0, 0, 1},
+			SrcPort: 49152,
+			DstPort: BFD_PORT_SINGLE_HOP,
+			TTL:     1,
+			Payload: []byte{
+				0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x19, 0x00, 0x0f, 0x42, 0x40,
+				0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+			},
+		},
+	},
+	{
+		Name: "LSP Ping over IPv6",
+		Data: []byte{
+			0x00, 0x06, 0x40, 0xff, // Label 100, TTL 255
+			0x00, 0x01, 0x01, 0xff, // Label 16, Bottom of Stack, TTL 255
+			0x60, 0x00, 0x00, 0x00, // IPv6
+			0x00, 0x14, 0x00, 0x01, // Payload Length 20, Hop-by-Hop, Hop Limit 1
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, // Source 2001:db8::1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Destination ::ffff:127.0.0.1
+			0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01,
+			0x11, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00, // Hop-by-Hop Options: Router Alert, PadN
+			0xc0, 0x00, 0x0d, 0xaf, // Ports 49152 -> 3503
+			0x00, 0x0c, 0x81, 0x65, // UDP Length 12, Checksum
+			0x01, 0x02, 0x03, 0x04, // Payload
+		},
+		Packet: MplsPacket{
+			Labels:      []uint32{100, 16},
+			Src:         net.ParseIP("2001:db8::1"),
+			Dst:         net.ParseIP("::ffff:127.0.0.1"),
+			SrcPort:     49152,
+			DstPort:     LSP_PING_PORT,
+			TTL:         1,
+			RouterAlert: true,
+			Payload:     []byte{0x01, 0x02, 0x03, 0x04},
+		},
+	},
+}
+
+func TestDecodeMpls(t *testing.T) {
+	for _, e := range mplsTests {
+		got, err := DecodeMpls(e.Data)
+		if err != nil {
+			t.Errorf("Error decoding for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("MPLS mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+func TestMarshalMpls(t *testing.T) {
+	for _, e := range mplsTests {
+		if got := e.Packet.Marshal(); !bytes.Equal(e.Data, got) {
+			t.Errorf("MPLS mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Data, got)
+		}
+	}
+}
+
+func TestDecodeInvalidMpls(t *testing.T) {
+	b := append([]byte{}, mplsTests[0].Data[:4]...)
+	b[2] &^= 0x01 // Bottom of Stack cleared
+	if _, err := DecodeMpls(b); !errors.Is(err, ErrPacketTooShort) {
+		t.Errorf("Label stack without bottom accepted, got %v", err)
+	}
+	if _, err := DecodeMpls(mplsTests[0].Data[:3]); !errors.Is(err, ErrPacketTooShort) {
+		t.Errorf("Truncated label stack accepted, got %v", err)
+	}
+
+	m := mplsTests[0].Packet
+	m.Dst = net.IPv4(192, 0, 2, 2)
+	if _, err := DecodeMpls(m.Marshal()); !errors.Is(err, ErrInvalidEncapsulation) {
+		t.Errorf("Non-loopback destination accepted, got %v", err)
+	}
+}
+
+/*
+ * Carries packets from the ingress down the LSP to the egress
+ */
+type lspLink struct {
+	t       *testing.T
+	lsp     Lsp
+	egress  *Manager
+	status  BfdStatus
+	session *Session
+}
+
+func (l *lspLink) deliver(dstPort uint16, payload []byte) (*MplsPacket, error) {
+	m := &MplsPacket{Labels: l.lsp.Labels, Src: l.lsp.Local, SrcPort: 49152, DstPort: dstPort, RouterAlert: dstPort == LSP_PING_PORT, Payload: payload}
+	return DecodeMpls(m.Marshal())
+}
+
+func (l *lspLink) Transmit(p *BfdControlPacket) error {
+	d, err := l.deliver(BFD_PORT_SINGLE_HOP, p.Marshal())
+	if err != nil {
+		return err
+	}
+	c, err := Decode(d.Payload)
+	if err != nil {
+		return err
+	}
+	return l.egress.Receive(&ReceivedPacket{Packet: c, Peer: &net.UDPAddr{IP: d.Src, Port: int(d.SrcPort)}, Local: d.Dst, TTL: d.TTL})
+}
+
+func (l *lspLink) Bootstrap(discr uint32) error {
+	d, err := l.deliver(LSP_PING_PORT, NewLspPingRequest(l.lsp.FEC, discr).Marshal())
+	if err != nil {
+		return err
+	}
+	if !d.RouterAlert {
+		l.t.Errorf("Echo request without Router Alert")
+	}
+	req, err := DecodeLspPing(d.Payload)
+	if err != nil {
+		return err
+	}
+
+	s, reply, err := l.egress.AcceptLspPing(req, d.Src, l.status, nil)
+	if err != nil {
+		return err
+	}
+	if reply == nil || reply.BfdDiscriminator != s.Status().LocalDiscr {
+		l.t.Errorf("Reply does not announce the egress discriminator, got %#v", reply)
+	}
+	l.session = s
+	return nil
+}
+
+func (l *lspLink) Close() error {
+	return nil
+}
+
+/*
+ * Carries packets from the egress back to the ingress over IP
+ */
+type returnLink struct {
+	ingress *Manager
+	local   net.IP
+}
+
+func (r *returnLink) Transmit(p *BfdControlPacket) error {
+	c, err := Decode(p.Marshal())
+	if err != nil {
+		return err
+	}
+	return r.ingress.Receive(&ReceivedPacket{Packet: c, Peer: &net.UDPAddr{IP: r.local, Port: 49152}, TTL: 254})
+}
+
+func (r *returnLink) Close() error {
+	return nil
+}
+
+func TestLspSessions(t *testing.T) {
+	ingressAddr := net.ParseIP("192.0.2.1")
+	egressAddr := net.ParseIP("192.0.2.2")
+	_, prefix, _ := net.ParseCIDR("192.0.2.2/32")
+
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+
+	ingress := NewMplsManager()
+	defer ingress.Close()
+	egress := NewMplsManager()
+	defer egress.Close()
+
+	link := &lspLink{t: t, egress: egress, status: status}
+	ingress.dialLsp = func(lsp Lsp) (lspSender, error) {
+		link.lsp = lsp
+		return link, nil
+	}
+	egress.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		if !peer.Equal(ingressAddr) {
+			t.Errorf("Egress session sends to %s", peer)
+		}
+		return &returnLink{ingress: ingress, local: egressAddr}, nil
+	}
+
+	if _, err := ingress.AddSession(egressAddr, ingressAddr, 1, status, nil); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("Session without LSP accepted, got %v", err)
+	}
+	lsp := Lsp{FEC: []LspPingTLV{LdpPrefixFEC(prefix)}, Labels: []uint32{16}, IfIndex: 1}
+	if _, err := ingress.AddLspSession(lsp, status, nil); !errors.Is(err, ErrNoLocalAddress) {
+		t.Errorf("LSP without local address accepted, got %v", err)
+	}
+
+	lsp.Local = ingressAddr
+	s, err := ingress.AddLspSession(lsp, status, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingress.AddLspSession(lsp, status, nil); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("Second session for the LSP accepted, got %v", err)
+	}
+
+	waitForState(t, s, STATE_UP)
+	waitForState(t, link.session, STATE_UP)
+	if got, want := link.session.Status().RemoteDiscr, s.Status().LocalDiscr; got != want {
+		t.Errorf("Egress remote discriminator mismatch, expected %d, got %d", want, got)
+	}
+
+	// Another echo request finds the same egress session
+	if err := ingress.SendLspPing(s); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(egress.Sessions()); n != 1 {
+		t.Errorf("Echo request for a known session created another, %d sessions", n)
+	}
+}
+
+func TestLspDemux(t *testing.T) {
+	ingressAddr := net.ParseIP("192.0.2.1")
+
+	m := NewMplsManager()
+	defer m.Close()
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return tailSender{}, nil
+	}
+
+	req := lspPingTests[0].Packet
+	if _, _, err := m.AcceptLspPing(req.Reply(1), ingressAddr, BfdStatusDefaults, nil); !errors.Is(err, ErrNotEchoRequest) {
+		t.Errorf("Echo reply accepted, got %v", err)
+	}
+	if _, _, err := NewManager().AcceptLspPing(&req, ingressAddr, BfdStatusDefaults, nil); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("Echo request accepted outside an MPLS manager, got %v", err)
+	}
+	if _, err := NewManager().AddLspSession(Lsp{}, BfdStatusDefaults, nil); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("LSP session added outside an MPLS manager, got %v", err)
+	}
+	req.ReplyMode = LSP_PING_REPLY_NONE
+	s, reply, err := m.AcceptLspPing(&req, ingressAddr, BfdStatusDefaults, nil)
+	if err != nil || reply != nil {
+		t.Fatalf("Echo request without reply mishandled: %v, %#v", err, reply)
+	}
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = req.BfdDiscriminator
+	r := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: ingressAddr, Port: 49152}}
+	if err := m.Receive(r); err != nil || s.State() != STATE_INIT {
+		t.Errorf("Packet from the ingress not delivered: %v", err)
+	}
+
+	p.YourDiscriminator = s.Status().LocalDiscr
+	r.Peer.IP = net.ParseIP("192.0.2.3")
+	if err := m.Receive(r); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Packet from another address accepted, got %v", err)
+	}
+	p.YourDiscriminator = 0
+	if err := m.Receive(r); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Packet from another ingress accepted, got %v", err)
+	}
+}
+
+/*
+ * An ingress restarting with a new discriminator replaces its egress
+ * session, leaving those of other FECs alone
+ */
+func TestLspIngressRestart(t *testing.T) {
+	ingressAddr := net.ParseIP("192.0.2.1")
+
+	m := NewMplsManager()
+	defer m.Close()
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return tailSender{}, nil
+	}
+
+	req := lspPingTests[0].Packet
+	old, _, err := m.AcceptLspPing(&req, ingressAddr, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, _ := net.ParseCIDR("198.51.100.0/24")
+	otherReq := NewLspPingRequest([]LspPingTLV{LdpPrefixFEC(other)}, 0x1111)
+	kept, _, err := m.AcceptLspPing(otherReq, ingressAddr, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.BfdDiscriminator = 0x87654321
+	s, _, err := m.AcceptLspPing(&req, ingressAddr, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == old || s.Status().RemoteDiscr != 0x87654321 {
+		t.Errorf("Session not replaced, remote discriminator %d", s.Status().RemoteDiscr)
+	}
+	if m.Session(old.Status().LocalDiscr) != nil {
+		t.Errorf("Old session not removed")
+	}
+	if m.Session(kept.Status().LocalDiscr) != kept || len(m.Sessions()) != 2 {
+		t.Errorf("Session of another FEC removed, %d sessions", len(m.Sessions()))
+	}
+}
+
+func TestLspReturnPort(t *testing.T) {
+	m := NewMplsManager()
+	defer m.Close()
+	if m.Transport().Port != BFD_PORT_SINGLE_HOP || m.LspReturnTransport().Port != BFD_PORT_MULTI_HOP {
+		t.Errorf("Ports mismatch, got %d and %d", m.Transport().Port, m.LspReturnTransport().Port)
+	}
+}
@@ -0,0 +1,28 @@
+package bfd
+
+const BFD_PORT_MULTI_HOP = 4784 // Control packets, RFC 5883
+
+/*
+ * Create a multihop transport (RFC 5883), accepting packets with a
+ * TTL / Hop Limit of at least minTTL
+ */
+func NewMultihopTransport(minTTL int, handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_MULTI_HOP,
+		MinTTL:  minTTL,
+		Handler: handler,
+	}
+}
+
+/*
+ * Create a manager for multihop sessions, identified by their (local,
+ * peer) address pair and accepting packets with a TTL / Hop Limit of at
+ * least minTTL
+ */
+func NewMultihopManager(minTTL int) *Manager {
+	m := newManager(true)
+	m.transport = NewMultihopTransport(minTTL, m.handle)
+	m.transport.Drop = m.countDrop
+
+	return m
+}
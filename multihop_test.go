@@ -0,0 +1,47 @@
+//go:build linux
+
+package bfd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+ * Two multihop sessions over loopback, distinguished by address pair
+ */
+func TestMultihopSessions(t *testing.T) {
+	port := freeUDPPort(t)
+	local := net.ParseIP("127.0.0.1")
+	peer := net.ParseIP("127.0.0.2")
+
+	m := NewMultihopManager(254)
+	m.Transport().Port = port
+	if err := m.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	a, err := m.AddSession(peer, local, 0, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.AddSession(local, peer, 0, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.AddSession(peer, nil, 0, BfdStatusDefaults, nil); !errors.Is(err, ErrNoLocalAddress) {
+		t.Errorf("Session without local address accepted, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for a.State() != STATE_UP || b.State() != STATE_UP {
+		if time.Now().After(deadline) {
+			t.Fatalf("Sessions did not come up, got %d and %d", a.State(), b.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
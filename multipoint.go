@@ -0,0 +1,136 @@
+package bfd
+
+import (
+	"net"
+)
+
+/*
+ * Role of a session, bfd.SessionType of RFC 8562
+ */
+type BfdSessionType uint8
+
+const (
+	SESSION_TYPE_POINT_TO_POINT  BfdSessionType = 0 // PointToPoint, RFC 5880
+	SESSION_TYPE_MULTIPOINT_HEAD BfdSessionType = 1 // MultipointHead, transmits only
+	SESSION_TYPE_MULTIPOINT_TAIL BfdSessionType = 2 // MultipointTail, receives only
+)
+
+func (s *Session) multipoint() bool {
+	return s.status.SessionType != SESSION_TYPE_POINT_TO_POINT
+}
+
+/*
+ * State of a session which is not administratively down: heads have
+ * nobody to wait for and are Up right away
+ */
+func enabledState(t BfdSessionType) BfdState {
+	if t == SESSION_TYPE_MULTIPOINT_HEAD {
+		return STATE_UP
+	}
+	return STATE_DOWN
+}
+
+/*
+ * Only tails accept packets with the Multipoint bit, and heads accept
+ * nothing at all
+ */
+func (s *Session) checkMultipoint(p *BfdControlPacket) error {
+	switch s.status.SessionType {
+	case SESSION_TYPE_MULTIPOINT_HEAD:
+		return ErrNoSession
+	case SESSION_TYPE_MULTIPOINT_TAIL:
+		if !p.Multipoint {
+			return ErrNoSession
+		}
+	default:
+		if p.Multipoint {
+			return ErrMultipointSet
+		}
+	}
+	return nil
+}
+
+/*
+ * Tail state machine: the head is only ever Up or AdminDown, and the
+ * tail follows it without a three-way handshake (RFC 8562)
+ */
+func (s *Session) receiveTail(p *BfdControlPacket) {
+	switch {
+	case s.status.SessionState == STATE_DOWN && p.State == STATE_UP:
+		s.status.SessionState = STATE_UP
+	case s.status.SessionState == STATE_UP && p.State != STATE_UP:
+		s.status.LocalDiag = DIAG_NEIGHBOR_SIGNAL_DOWN
+		s.status.SessionState = STATE_DOWN
+	}
+}
+
+/*
+ * Tails have no use for a sender
+ */
+type tailSender struct{}
+
+func (tailSender) Transmit(p *BfdControlPacket) error {
+	return nil
+}
+
+func (tailSender) Close() error {
+	return nil
+}
+
+/*
+ * Create and start a multipoint head sending to group, which may be a
+ * multicast or unicast address
+ *
+ * The head is Up unless status says AdminDown, and transmits with the
+ * Multipoint bit at DesiredMinTxInterval whether or not any tail
+ * listens. Its LocalDiscr is what tails are configured with.
+ */
+func (m *Manager) AddMultipointHead(group net.IP, local net.IP, ifIndex int, status BfdStatus) (*Session, error) {
+	if m.overlay || m.micro || m.loopback || m.mpls {
+		return nil, ErrMultipointUnsupported
+	}
+	if m.multihop && (local == nil || local.IsUnspecified()) {
+		return nil, ErrNoLocalAddress
+	}
+
+	status.SessionType = SESSION_TYPE_MULTIPOINT_HEAD
+	dial := func() (sessionSender, error) {
+		return m.dial(local, group, ifIndex)
+	}
+	return m.addSession(m.key(group, local, ifIndex, 0), dial, status, nil)
+}
+
+/*
+ * Create and start a multipoint tail monitoring the head at address
+ * head, which transmits with My Discriminator headDiscr
+ *
+ * Tails never transmit. Packets sent to a multicast group are only
+ * received after joining it with JoinGroup.
+ */
+func (m *Manager) AddMultipointTail(head net.IP, headDiscr uint32, local net.IP, ifIndex int, status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	if m.overlay || m.micro || m.loopback || m.mpls {
+		return nil, ErrMultipointUnsupported
+	}
+	if headDiscr == 0 {
+		return nil, ErrZeroMyDiscriminator
+	}
+	if m.multihop && (local == nil || local.IsUnspecified()) {
+		return nil, ErrNoLocalAddress
+	}
+
+	status.SessionType = SESSION_TYPE_MULTIPOINT_TAIL
+	key := m.key(head, local, ifIndex, 0)
+	key.discr = headDiscr
+	dial := func() (sessionSender, error) {
+		return tailSender{}, nil
+	}
+	return m.addSession(key, dial, status, fn)
+}
+
+/*
+ * Receive packets sent to a multicast group on the given interface,
+ * zero letting the system choose
+ */
+func (m *Manager) JoinGroup(group net.IP, ifIndex int) error {
+	return m.transport.JoinGroup(group, ifIndex)
+}
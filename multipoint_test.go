@@ -0,0 +1,196 @@
+package bfd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultipointHead(t *testing.T) {
+	status := BfdStatusDefaults
+	status.LocalDiscr = 5
+	status.DesiredMinTxInterval = 50000
+	status.SessionType = SESSION_TYPE_MULTIPOINT_HEAD
+	head := NewSession(status, nil)
+
+	if head.State() != STATE_UP {
+		t.Fatalf("Head not Up, got %d", head.State())
+	}
+
+	p := head.ControlPacket()
+	if !p.Multipoint || p.State != STATE_UP || p.YourDiscriminator != 0 || p.RequiredMinRxInterval != 0 || p.DesiredMinTxInterval != 50000 {
+		t.Errorf("Head packet mismatch, got %#v", p)
+	}
+	if err := p.Validate(); err != nil {
+		t.Errorf("Head packet rejected: %v", err)
+	}
+
+	reply := BfdControlPacketDefaults
+	reply.MyDiscriminator = 9
+	reply.YourDiscriminator = 5
+	if err := head.Receive(&reply); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Head accepted a packet, got %v", err)
+	}
+
+	head.SetAdminDown(DIAG_ADMIN_DOWN)
+	if p := head.ControlPacket(); p.State != STATE_ADMIN_DOWN || !p.Multipoint {
+		t.Errorf("Head AdminDown packet mismatch, got %#v", p)
+	}
+	head.SetAdminUp()
+	if head.State() != STATE_UP {
+		t.Errorf("Head not Up after admin up, got %d", head.State())
+	}
+}
+
+func TestMultipointTail(t *testing.T) {
+	hs := BfdStatusDefaults
+	hs.LocalDiscr = 5
+	hs.DesiredMinTxInterval = 50000
+	hs.SessionType = SESSION_TYPE_MULTIPOINT_HEAD
+	head := NewSession(hs, nil)
+
+	var changes []BfdState
+	ts := BfdStatusDefaults
+	ts.LocalDiscr = 7
+	ts.SessionType = SESSION_TYPE_MULTIPOINT_TAIL
+	tail := NewSession(ts, func(s *Session, old BfdState, new BfdState) {
+		changes = append(changes, new)
+	})
+
+	if err := tail.Receive(head.ControlPacket()); err != nil {
+		t.Fatal(err)
+	}
+	if tail.State() != STATE_UP || tail.Status().RemoteDiscr != 5 {
+		t.Errorf("Tail did not come up on the first head packet, got %#v", tail.Status())
+	}
+	if d := tail.DetectionTime(); d != 150*time.Millisecond {
+		t.Errorf("Tail detection time mismatch, expected 150ms, got %v", d)
+	}
+
+	// Heads change rate without a Poll Sequence, tails follow at once
+	head.SetParameters(10000, 0, 2)
+	if p := head.ControlPacket(); p.Poll {
+		t.Errorf("Head started a Poll Sequence")
+	}
+	tail.Receive(head.ControlPacket())
+	if d := tail.DetectionTime(); d != 20*time.Millisecond {
+		t.Errorf("Tail detection time mismatch, expected 20ms, got %v", d)
+	}
+
+	head.SetAdminDown(DIAG_ADMIN_DOWN)
+	tail.Receive(head.ControlPacket())
+	if got := tail.Status(); got.SessionState != STATE_DOWN || got.LocalDiag != DIAG_NEIGHBOR_SIGNAL_DOWN {
+		t.Errorf("Tail did not follow head AdminDown, got %#v", got)
+	}
+
+	head.SetAdminUp()
+	tail.Receive(head.ControlPacket())
+	tail.DetectionTimeExpired()
+	if got := tail.Status(); got.SessionState != STATE_DOWN || got.LocalDiag != DIAG_TIME_EXPIRED {
+		t.Errorf("Tail did not time out, got %#v", got)
+	}
+
+	expected := []BfdState{STATE_UP, STATE_DOWN, STATE_UP, STATE_DOWN}
+	if len(changes) != len(expected) {
+		t.Fatalf("State changes mismatch, expected %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("State changes mismatch, expected %v, got %v", expected, changes)
+		}
+	}
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 5
+	if err := tail.Receive(&p); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Tail accepted a point-to-point packet, got %v", err)
+	}
+}
+
+/*
+ * Tails never transmit, even while Up
+ */
+func TestMultipointTailSilent(t *testing.T) {
+	hs := BfdStatusDefaults
+	hs.LocalDiscr = 5
+	hs.SessionType = SESSION_TYPE_MULTIPOINT_HEAD
+	head := NewSession(hs, nil)
+
+	ts := BfdStatusDefaults
+	ts.LocalDiscr = 7
+	ts.SessionType = SESSION_TYPE_MULTIPOINT_TAIL
+	tail := NewSession(ts, nil)
+
+	sent := make(chan *BfdControlPacket, 1)
+	tail.Start(func(p *BfdControlPacket) error {
+		sent <- p
+		return nil
+	})
+	defer tail.Stop()
+
+	p := head.ControlPacket()
+	p.Poll = true
+	tail.Receive(p)
+
+	select {
+	case p := <-sent:
+		t.Errorf("Tail transmitted %#v", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMultipointPointToPoint(t *testing.T) {
+	status := BfdStatusDefaults
+	status.LocalDiscr = 1
+	s := NewSession(status, nil)
+
+	p := BfdControlPacketDefaults
+	p.State = STATE_UP
+	p.Multipoint = true
+	p.MyDiscriminator = 5
+	if err := s.Receive(&p); !errors.Is(err, ErrMultipointSet) {
+		t.Errorf("Point-to-point session accepted a multipoint packet, got %v", err)
+	}
+}
+
+func TestDemuxMultipoint(t *testing.T) {
+	d := newDemux()
+
+	status := BfdStatusDefaults
+	status.LocalDiscr = 7
+	s := NewSession(status, nil)
+	d.add(sessionKey{peer: "10.0.0.1"}, s)
+
+	status.LocalDiscr = 8
+	status.SessionType = SESSION_TYPE_MULTIPOINT_TAIL
+	tail := NewSession(status, nil)
+	if err := d.add(sessionKey{peer: "10.0.0.1", discr: 5}, tail); err != nil {
+		t.Fatal(err)
+	}
+
+	p := BfdControlPacketDefaults
+	p.State = STATE_UP
+	p.Multipoint = true
+	p.MyDiscriminator = 5
+
+	if got, err := d.lookup(sessionKey{peer: "10.0.0.1", ifIndex: 2}, &p); got != tail || err != nil {
+		t.Errorf("Tail not found by head address and discriminator: %v", err)
+	}
+	if _, err := d.lookup(sessionKey{peer: "10.0.0.2"}, &p); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Tail found from another head, got %v", err)
+	}
+	p.MyDiscriminator = 6
+	if _, err := d.lookup(sessionKey{peer: "10.0.0.1"}, &p); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Tail found with another head discriminator, got %v", err)
+	}
+
+	p.Multipoint = false
+	p.State = STATE_DOWN
+	if got, _ := d.lookup(sessionKey{peer: "10.0.0.1"}, &p); got != s {
+		t.Errorf("Point-to-point session not found next to tail")
+	}
+	p.YourDiscriminator = 8
+	if _, err := d.lookup(sessionKey{peer: "10.0.0.1"}, &p); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Tail found by its own discriminator, got %v", err)
+	}
+}
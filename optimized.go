@@ -0,0 +1,161 @@
+package bfd
+
+import (
+	"bytes"
+	"crypto/subtle"
+)
+
+/*
+ * Optimized authentication (draft-ietf-bfd-optimizing-authentication)
+ *
+ * Packets that bring up, take down or change the parameters of a
+ * session are fully authenticated with the MD5 or SHA-1 digest of an
+ * Optimized key. Once both ends are Up, packets identical to the last
+ * fully authenticated one carry Meticulous Keyed ISAAC authentication
+ * instead (draft-ietf-bfd-secure-sequence-numbers), which only costs a
+ * pseudo-random number per packet.
+ *
+ * ISAAC is only sent after the peer has shown it supports Optimized
+ * authentication by sending a fully authenticated packet of the same
+ * type. A peer sending the equivalent plain Meticulous type instead is
+ * answered with that type on every packet.
+ */
+
+/*
+ * Optimized types fall back to the Meticulous type using the same
+ * digest
+ */
+func (t AuthenticationType) optimized() bool {
+	return t == BFD_AUTH_TYPE_OPTIMIZED_MD5 || t == BFD_AUTH_TYPE_OPTIMIZED_SHA1
+}
+
+func (t AuthenticationType) fallback() AuthenticationType {
+	switch t {
+	case BFD_AUTH_TYPE_OPTIMIZED_MD5:
+		return BFD_AUTH_TYPE_METICULOUS_MD5
+	case BFD_AUTH_TYPE_OPTIMIZED_SHA1:
+		return BFD_AUTH_TYPE_METICULOUS_SHA1
+	}
+	return t
+}
+
+/*
+ * Report whether steady state packets are currently sent with the
+ * cheap ISAAC authentication
+ */
+func (s *Session) AuthOptimized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.authOptimized && !s.authFallback
+}
+
+func (s *Session) resetOptimizedAuth() {
+	s.authOptimized = false
+	s.authFallback = false
+	s.isaacTx = nil
+	s.isaacRx = nil
+}
+
+/*
+ * Steady state packets change nothing at the receiving end
+ */
+func steadyState(p *BfdControlPacket) bool {
+	return p.State == STATE_UP && !p.Poll && !p.Final
+}
+
+/*
+ * Packet contents without the authentication section
+ */
+func unauthenticatedContent(p *BfdControlPacket) []byte {
+	c := *p
+	c.AuthPresent = false
+	c.AuthHeader = nil
+	return c.Marshal()
+}
+
+/*
+ * Authenticate an outgoing packet with an Optimized key; content is
+ * the packet without its authentication section
+ */
+func (s *Session) signOptimized(p *BfdControlPacket, k Key, content []byte) error {
+	seq := s.status.XmitAuthSeq
+	p.AuthPresent = true
+
+	tx := s.isaacTx
+	if s.authOptimized && !s.authFallback && tx != nil && tx.keyID == k.ID &&
+		steadyState(p) && bytes.Equal(content, tx.content) {
+		if v, ok := tx.value(seq, 1); ok {
+			tx.consume(seq)
+			p.AuthHeader = &BfdAuthHeader{
+				Type:           BFD_AUTH_TYPE_METICULOUS_ISAAC,
+				AuthKeyID:      k.ID,
+				SequenceNumber: seq,
+				AuthData:       v,
+			}
+			return nil
+		}
+	}
+
+	t := k.Type
+	if s.authFallback {
+		t = t.fallback()
+	}
+	p.AuthHeader = &BfdAuthHeader{
+		Type:           t,
+		AuthKeyID:      k.ID,
+		SequenceNumber: seq,
+	}
+	if err := p.Sign(k.Secret); err != nil {
+		return err
+	}
+
+	s.isaacTx = nil
+	if !s.authFallback {
+		s.isaacTx = newIsaacStream(k, s.status.LocalDiscr, seq, content)
+	}
+	return nil
+}
+
+/*
+ * Check a received packet against an Optimized key
+ */
+func (s *Session) authenticateOptimized(p *BfdControlPacket, k Key) error {
+	switch p.AuthHeader.Type {
+	case k.Type, k.Type.fallback():
+		if !s.authSequenceValid(p) {
+			return ErrAuthSequence
+		}
+		if err := p.Verify(k.Secret); err != nil {
+			return err
+		}
+
+		s.isaacRx = nil
+		if p.AuthHeader.Type == k.Type {
+			s.authOptimized = true
+			s.authFallback = false
+			s.isaacRx = newIsaacStream(k, p.MyDiscriminator, p.AuthHeader.SequenceNumber, unauthenticatedContent(p))
+		} else {
+			s.authFallback = true
+		}
+		return nil
+
+	case BFD_AUTH_TYPE_METICULOUS_ISAAC:
+		rx := s.isaacRx
+		if rx == nil || rx.keyID != k.ID || !steadyState(p) || !bytes.Equal(unauthenticatedContent(p), rx.content) {
+			return ErrAuthFailed
+		}
+		if !s.authSequenceValid(p) {
+			return ErrAuthSequence
+		}
+
+		v, ok := rx.value(p.AuthHeader.SequenceNumber, 3*uint32(p.DetectMult))
+		if !ok || subtle.ConstantTimeCompare(v, p.AuthHeader.AuthData) != 1 {
+			return ErrAuthFailed
+		}
+		rx.consume(p.AuthHeader.SequenceNumber)
+		return nil
+	}
+
+	return ErrAuthTypeMismatch
+}
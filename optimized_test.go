@@ -0,0 +1,162 @@
+package bfd
+
+import (
+	"errors"
+	"testing"
+)
+
+/*
+ * First results of ISAAC seeded with zeros, from Bob Jenkins' randvect.txt
+ */
+func TestIsaac(t *testing.T) {
+	expected := []uint32{0xf650e4c8, 0xe448e96d, 0x98db2fb4, 0xf5fad54f, 0x433f1afb, 0xedec154a, 0xd8370487, 0x46ca4f9a}
+
+	var r isaac
+	r.seed(nil)
+	r.generate()
+	for i, v := range expected {
+		if r.rsl[i] != v {
+			t.Errorf("Result %d mismatch, expected %08x, got %08x", i, v, r.rsl[i])
+		}
+	}
+}
+
+func newAuthenticatedPair(t *testing.T, ta AuthenticationType, tb AuthenticationType) (*Session, *Session) {
+	a := BfdStatusDefaults
+	a.LocalDiscr = 1
+	b := BfdStatusDefaults
+	b.LocalDiscr = 2
+
+	sa := NewSession(a, nil)
+	sb := NewSession(b, nil)
+	if err := sa.SetAuthentication(ta, 1, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.SetAuthentication(tb, 1, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	return sa, sb
+}
+
+func TestOptimizedAuthentication(t *testing.T) {
+	sa, sb := newAuthenticatedPair(t, BFD_AUTH_TYPE_OPTIMIZED_SHA1, BFD_AUTH_TYPE_OPTIMIZED_SHA1)
+
+	var last *BfdControlPacket
+	exchange := func() {
+		t.Helper()
+		last = sentPacket(t, sa)
+		if err := sb.Receive(last); err != nil {
+			t.Fatal(err)
+		}
+		if err := sa.Receive(sentPacket(t, sb)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		exchange()
+		if last.AuthHeader.Type != BFD_AUTH_TYPE_OPTIMIZED_SHA1 {
+			t.Errorf("Packet %d while coming up not fully authenticated", i)
+		}
+	}
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Fatalf("Sessions did not come up")
+	}
+
+	// Steady state uses ISAAC
+	for i := 0; i < 300; i++ {
+		exchange()
+		if last.AuthHeader.Type != BFD_AUTH_TYPE_METICULOUS_ISAAC {
+			t.Fatalf("Steady state packet %d not optimized, got type %d", i, last.AuthHeader.Type)
+		}
+	}
+	if !sa.AuthOptimized() || !sb.AuthOptimized() {
+		t.Errorf("Optimized authentication not negotiated")
+	}
+
+	if err := sb.Receive(last); !errors.Is(err, ErrAuthSequence) {
+		t.Errorf("Replayed packet accepted, got %v", err)
+	}
+
+	p := sentPacket(t, sa)
+	p.AuthHeader.AuthData = []byte{0, 0, 0, 0}
+	if err := sb.Receive(p); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Forged ISAAC packet accepted, got %v", err)
+	}
+
+	// Changes must be fully authenticated
+	p = sentPacket(t, sa)
+	p.State = STATE_DOWN
+	p.Diagnostic = DIAG_ADMIN_DOWN
+	if err := sb.Receive(p); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("State change with ISAAC accepted, got %v", err)
+	}
+
+	// Losing a few packets is fine
+	sentPacket(t, sa)
+	sentPacket(t, sa)
+	exchange()
+
+	sa.SetParameters(2000000, 1000000, 3)
+	last = sentPacket(t, sa)
+	if last.AuthHeader.Type != BFD_AUTH_TYPE_OPTIMIZED_SHA1 || !last.Poll {
+		t.Errorf("Poll not fully authenticated")
+	}
+	if err := sb.Receive(last); err != nil {
+		t.Fatal(err)
+	}
+
+	sb.mu.Lock()
+	final := sb.controlPacket()
+	final.Final = true
+	sb.sign(final)
+	sb.mu.Unlock()
+	if final.AuthHeader.Type != BFD_AUTH_TYPE_OPTIMIZED_SHA1 {
+		t.Errorf("Final not fully authenticated")
+	}
+	if err := sa.Receive(final); err != nil {
+		t.Fatal(err)
+	}
+
+	exchange()
+	if last.AuthHeader.Type != BFD_AUTH_TYPE_OPTIMIZED_SHA1 {
+		t.Errorf("New parameters not fully authenticated")
+	}
+	exchange()
+	if last.AuthHeader.Type != BFD_AUTH_TYPE_METICULOUS_ISAAC {
+		t.Errorf("Steady state not resumed after parameter change")
+	}
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Sessions went down")
+	}
+}
+
+func TestOptimizedAuthenticationFallback(t *testing.T) {
+	sa, sb := newAuthenticatedPair(t, BFD_AUTH_TYPE_OPTIMIZED_MD5, BFD_AUTH_TYPE_METICULOUS_MD5)
+
+	// The peer does not know the Optimized type
+	if err := sb.Receive(sentPacket(t, sa)); !errors.Is(err, ErrAuthTypeMismatch) {
+		t.Errorf("Optimized packet accepted by Meticulous session, got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := sa.Receive(sentPacket(t, sb)); err != nil {
+			t.Fatal(err)
+		}
+		p := sentPacket(t, sa)
+		if p.AuthHeader.Type != BFD_AUTH_TYPE_METICULOUS_MD5 {
+			t.Fatalf("No fallback to Meticulous Keyed MD5, got type %d", p.AuthHeader.Type)
+		}
+		if err := sb.Receive(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Sessions did not come up")
+	}
+	if sa.AuthOptimized() {
+		t.Errorf("Optimized authentication used with a Meticulous peer")
+	}
+}
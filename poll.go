@@ -0,0 +1,134 @@
+package bfd
+
+import (
+	"time"
+)
+
+/*
+ * Session parameters whose change while Up requires a Poll Sequence
+ * (RFC 5880 section 6.8.3)
+ */
+type pollParams struct {
+	desiredMinTxInterval  time.Duration
+	requiredMinRxInterval time.Duration
+	detectMult            uint8
+	demandMode            bool
+}
+
+func (s *Session) params() pollParams {
+	return pollParams{
+		desiredMinTxInterval:  s.desiredMinTxInterval(),
+		requiredMinRxInterval: s.requiredMinRxInterval(),
+		detectMult:            s.status.DetectMult,
+		demandMode:            s.status.DemandMode,
+	}
+}
+
+/*
+ * Change the local timer parameters, intervals in microseconds
+ *
+ * While the session is Up the new values are announced with a Poll
+ * Sequence and the previous ones stay in effect until it completes.
+ */
+func (s *Session) SetParameters(desiredMinTx time.Duration, requiredMinRx time.Duration, detectMult uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.params()
+	s.status.DesiredMinTxInterval = desiredMinTx
+	s.status.RequiredMinRxInterval = requiredMinRx
+	s.status.DetectMult = detectMult
+
+	s.checkPoll(before)
+}
+
+/*
+ * Report whether a Poll Sequence is in progress
+ */
+func (s *Session) PollInProgress() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.polling
+}
+
+/*
+ * Start a Poll Sequence if the parameters differ from before while Up,
+ * and drop any Poll Sequence once the session is no longer Up.
+ * Multipoint sessions apply new parameters without one.
+ */
+func (s *Session) checkPoll(before pollParams) {
+	// Tails never answer a Poll
+	if s.multipoint() {
+		return
+	}
+
+	if s.status.SessionState != STATE_UP {
+		s.polling = false
+		s.completePoll(false)
+		return
+	}
+
+	// Only one Poll Sequence at a time, the Final will pick up the rest
+	if !s.polling && s.params() != before {
+		s.startPoll(before)
+	}
+}
+
+func (s *Session) startPoll(before pollParams) {
+	s.polling = true
+	s.pollBefore = before
+	s.pollSent = s.params()
+
+	if !s.running {
+		return
+	}
+
+	// Send the first Poll now, and in Demand mode time the answer
+	s.txTimer.Reset(0)
+	if s.demandActive() {
+		s.restartDetectionTimer()
+	}
+}
+
+/*
+ * Terminate the Poll Sequence on receipt of a Final, and start another
+ * if the parameters changed again in the meantime
+ */
+func (s *Session) receiveFinal() {
+	if !s.polling {
+		return
+	}
+
+	s.polling = false
+	s.completePoll(true)
+	if s.status.SessionState == STATE_UP && s.params() != s.pollSent {
+		s.startPoll(s.pollSent)
+	}
+}
+
+/*
+ * Desired Min TX Interval driving the transmit timer; during a Poll
+ * Sequence an increase only takes effect once the peer acknowledged it
+ */
+func (s *Session) txDesiredMinTxInterval() time.Duration {
+	interval := s.desiredMinTxInterval()
+	if s.polling && s.pollBefore.desiredMinTxInterval < interval {
+		return s.pollBefore.desiredMinTxInterval
+	}
+
+	return interval
+}
+
+/*
+ * Required Min RX Interval driving the detection time; during a Poll
+ * Sequence a decrease only takes effect once the peer acknowledged it
+ */
+func (s *Session) detectRequiredMinRxInterval() time.Duration {
+	interval := s.requiredMinRxInterval()
+	if s.polling && s.pollBefore.requiredMinRxInterval > interval {
+		return s.pollBefore.requiredMinRxInterval
+	}
+
+	return interval
+}
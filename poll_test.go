@@ -0,0 +1,125 @@
+package bfd
+
+import (
+	"testing"
+	"time"
+)
+
+func newUpSession() *Session {
+	status := BfdStatusDefaults
+	status.SessionState = STATE_UP
+	status.RemoteSessionState = STATE_UP
+	status.LocalDiscr = 1
+	status.RemoteDiscr = 2
+	status.RemoteMinRxInterval = 10000
+	status.RemoteDesiredMinTxInterval = 10000
+	status.RemoteDetectMult = 3
+
+	return NewSession(status, nil)
+}
+
+func finalPacket() *BfdControlPacket {
+	p := BfdControlPacketDefaults
+	p.State = STATE_UP
+	p.Final = true
+	p.MyDiscriminator = 2
+	p.YourDiscriminator = 1
+	p.DesiredMinTxInterval = 10000
+	p.RequiredMinRxInterval = 10000
+
+	return &p
+}
+
+func TestPollSequence(t *testing.T) {
+	s := newUpSession()
+
+	// Slower transmission waits for the Final
+	s.SetParameters(2000000, 1000000, 3)
+	if !s.PollInProgress() || !s.ControlPacket().Poll {
+		t.Fatalf("No Poll Sequence after parameter change")
+	}
+	if got := s.TransmitInterval(); got != time.Second {
+		t.Errorf("Transmit interval changed before Final, got %v", got)
+	}
+
+	s.Receive(finalPacket())
+	if s.PollInProgress() || s.ControlPacket().Poll {
+		t.Errorf("Poll Sequence not terminated by Final")
+	}
+	if got := s.TransmitInterval(); got != 2*time.Second {
+		t.Errorf("Transmit interval not changed after Final, got %v", got)
+	}
+
+	// Faster reception waits for the Final
+	s.SetParameters(2000000, 10000, 3)
+	if got := s.DetectionTime(); got != 3*time.Second {
+		t.Errorf("Detection time changed before Final, got %v", got)
+	}
+	s.Receive(finalPacket())
+	if got := s.DetectionTime(); got != 30*time.Millisecond {
+		t.Errorf("Detection time not changed after Final, got %v", got)
+	}
+
+	// Unchanged parameters need no Poll Sequence
+	s.SetParameters(2000000, 10000, 3)
+	if s.PollInProgress() {
+		t.Errorf("Poll Sequence without parameter change")
+	}
+}
+
+func TestPollSequenceQueued(t *testing.T) {
+	s := newUpSession()
+
+	s.SetParameters(1000000, 1000000, 5)
+	s.SetParameters(1000000, 1000000, 7)
+	if got := s.ControlPacket().DetectMult; got != 7 {
+		t.Errorf("Detect Mult not advertised, got %d", got)
+	}
+
+	// The Final only covers the first change
+	s.Receive(finalPacket())
+	if !s.PollInProgress() {
+		t.Errorf("Second Poll Sequence not started")
+	}
+	s.Receive(finalPacket())
+	if s.PollInProgress() {
+		t.Errorf("Second Poll Sequence not terminated")
+	}
+
+	// Leaving Up abandons the Poll Sequence
+	s.SetParameters(1000000, 1000000, 3)
+	s.DetectionTimeExpired()
+	if s.PollInProgress() {
+		t.Errorf("Poll Sequence survived session going Down")
+	}
+}
+
+func TestPollAnsweredWithFinal(t *testing.T) {
+	sent := make(chan *BfdControlPacket, 16)
+	s := newUpSession()
+	s.Start(func(p *BfdControlPacket) error {
+		sent <- p
+		return nil
+	})
+	defer s.Stop()
+
+	p := finalPacket()
+	p.Final = false
+	p.Poll = true
+	s.Receive(p)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case got := <-sent:
+			if got.Final {
+				if got.Poll {
+					t.Errorf("Final sent with Poll set")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Poll not answered with Final")
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package bfd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const BFD_PORT_SBFD = 7784 // Seamless BFD reflectors, RFC 7881
+
+/*
+ * Seamless BFD reflector (RFC 7880 section 7.2.2)
+ *
+ * Answers S-BFD control packets addressed to any of its target
+ * discriminators, without keeping any per-initiator state.
+ */
+type Reflector struct {
+	MinRxInterval time.Duration // Required Min RX Interval to advertise, in microseconds
+
+	transport *Transport
+
+	mu        sync.Mutex
+	discrs    map[uint32]bool
+	adminDown bool
+	drops     map[error]uint64
+}
+
+func NewReflector() *Reflector {
+	r := &Reflector{
+		MinRxInterval: 1000000,
+		discrs:        make(map[uint32]bool),
+		drops:         make(map[error]uint64),
+	}
+	r.transport = &Transport{
+		Port:    BFD_PORT_SBFD,
+		MinTTL:  0,
+		Handler: r.handle,
+		Drop:    r.countDrop,
+	}
+
+	return r
+}
+
+/*
+ * Transport used by the reflector, to adjust the port before calling
+ * Listen
+ */
+func (r *Reflector) Transport() *Transport {
+	return r.transport
+}
+
+func (r *Reflector) Listen() error {
+	return r.transport.Listen()
+}
+
+func (r *Reflector) Close() error {
+	return r.transport.Close()
+}
+
+/*
+ * Start reflecting packets addressed to discr
+ */
+func (r *Reflector) AddDiscriminator(discr uint32) error {
+	if discr == 0 {
+		return ErrZeroMyDiscriminator
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.discrs[discr] = true
+	return nil
+}
+
+func (r *Reflector) RemoveDiscriminator(discr uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.discrs, discr)
+}
+
+/*
+ * Answer with AdminDown instead of Up, telling initiators to stop
+ * using the targets
+ */
+func (r *Reflector) SetAdminDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.adminDown = down
+}
+
+/*
+ * Build the response to a packet from an initiator
+ */
+func (r *Reflector) Reflect(p *BfdControlPacket) (*BfdControlPacket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.discrs[p.YourDiscriminator] {
+		return nil, ErrUnknownDiscriminator
+	}
+	if p.AuthPresent {
+		return nil, ErrAuthMismatch
+	}
+
+	resp := BfdControlPacketDefaults
+	resp.State = STATE_UP
+	if r.adminDown {
+		resp.State = STATE_ADMIN_DOWN
+		resp.Diagnostic = DIAG_ADMIN_DOWN
+	}
+	resp.Final = p.Poll
+	resp.DetectMult = p.DetectMult
+	resp.MyDiscriminator = p.YourDiscriminator
+	resp.YourDiscriminator = p.MyDiscriminator
+	resp.DesiredMinTxInterval = p.DesiredMinTxInterval
+	resp.RequiredMinRxInterval = r.MinRxInterval
+	resp.RequiredMinEchoRxInterval = 0
+
+	return &resp, nil
+}
+
+func (r *Reflector) handle(rp *ReceivedPacket) {
+	resp, err := r.Reflect(rp.Packet)
+	if err == nil {
+		err = rp.Reply(resp)
+	}
+	if err != nil {
+		r.countDrop(err, rp.Peer)
+	}
+}
+
+/*
+ * Number of packets not reflected, by reason
+ */
+func (r *Reflector) Drops() map[error]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	drops := make(map[error]uint64, len(r.drops))
+	for reason, n := range r.drops {
+		drops[reason] = n
+	}
+	return drops
+}
+
+func (r *Reflector) countDrop(err error, peer *net.UDPAddr) {
+	r.mu.Lock()
+	r.drops[dropReason(err)]++
+	r.mu.Unlock()
+}
+
+/*
+ * Fold decode and receive errors into the reason they are counted under
+ */
+func dropReason(err error) error {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return de.Reason
+	}
+	if errors.Is(err, ErrReceiveFailed) {
+		return ErrReceiveFailed
+	}
+	return err
+}
+
+/*
+ * Called after every change of an initiator's reachability state
+ */
+type InitiatorStateFunc func(i *Initiator, old BfdState, new BfdState)
+
+/*
+ * Seamless BFD initiator (RFC 7880 section 7.2.1)
+ *
+ * Sends S-BFD control packets to a target discriminator and considers
+ * it Up while responses come back within the detection time. There is
+ * no three-way handshake: a single Up response brings the initiator Up.
+ */
+type Initiator struct {
+	mu         sync.Mutex
+	discr      uint32
+	target     uint32
+	interval   time.Duration
+	detectMult uint8
+	state      BfdState
+	diag       BfdDiagnostic
+	onChange   InitiatorStateFunc
+
+	running     bool
+	tx          TransmitFunc
+	txTimer     *time.Timer
+	detectTimer *time.Timer
+	lastRx      time.Time
+	sender      *Sender
+	wg          sync.WaitGroup
+	drops       map[error]uint64
+}
+
+/*
+ * Create an initiator with local discriminator discr for the target
+ * discriminator, sending every interval (in microseconds)
+ */
+func NewInitiator(discr uint32, target uint32, interval time.Duration, detectMult uint8, fn InitiatorStateFunc) *Initiator {
+	return &Initiator{
+		discr:      discr,
+		target:     target,
+		interval:   interval,
+		detectMult: detectMult,
+		state:      STATE_DOWN,
+		onChange:   fn,
+		drops:      make(map[error]uint64),
+	}
+}
+
+func (i *Initiator) State() BfdState {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.state
+}
+
+/*
+ * Diagnostic explaining the last transition to Down
+ */
+func (i *Initiator) Diagnostic() BfdDiagnostic {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.diag
+}
+
+/*
+ * Build the next packet to send to the reflector
+ */
+func (i *Initiator) ControlPacket() *BfdControlPacket {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.controlPacket()
+}
+
+func (i *Initiator) controlPacket() *BfdControlPacket {
+	p := BfdControlPacketDefaults
+
+	p.Diagnostic = i.diag
+	p.State = i.state
+	p.Demand = true
+	p.DetectMult = i.detectMult
+	p.MyDiscriminator = i.discr
+	p.YourDiscriminator = i.target
+	p.DesiredMinTxInterval = i.interval
+	p.RequiredMinRxInterval = 0
+	p.RequiredMinEchoRxInterval = 0
+
+	return &p
+}
+
+/*
+ * Update the initiator from a reflected packet
+ */
+func (i *Initiator) Receive(p *BfdControlPacket) error {
+	if p.YourDiscriminator != i.discr {
+		return ErrUnknownDiscriminator
+	}
+	if p.MyDiscriminator != i.target {
+		return ErrAddressMismatch
+	}
+
+	i.mu.Lock()
+	old := i.state
+	switch p.State {
+	case STATE_UP:
+		i.state = STATE_UP
+		i.diag = DIAG_NONE
+	case STATE_ADMIN_DOWN:
+		if i.state == STATE_UP {
+			i.diag = DIAG_NEIGHBOR_SIGNAL_DOWN
+		}
+		i.state = STATE_DOWN
+	}
+	if i.running {
+		i.lastRx = time.Now()
+		i.detectTimer.Reset(i.detectionTime())
+	}
+	state := i.state
+	i.mu.Unlock()
+
+	i.notify(old, state)
+	return nil
+}
+
+/*
+ * Time without a response after which the target is declared Down
+ */
+func (i *Initiator) detectionTime() time.Duration {
+	return time.Duration(i.detectMult) * microseconds(i.interval)
+}
+
+/*
+ * Start sending packets through tx, responses must be passed to Receive
+ */
+func (i *Initiator) Start(tx TransmitFunc) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.running {
+		return
+	}
+
+	i.running = true
+	i.tx = tx
+	i.lastRx = time.Now()
+	i.txTimer = time.AfterFunc(0, i.transmitTimerExpired)
+	i.detectTimer = time.AfterFunc(i.detectionTime(), i.detectTimerExpired)
+}
+
+func (i *Initiator) Stop() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.running = false
+	if i.txTimer != nil {
+		i.txTimer.Stop()
+	}
+	if i.detectTimer != nil {
+		i.detectTimer.Stop()
+	}
+}
+
+/*
+ * Probe a reflector at peer on port, normally BFD_PORT_SBFD
+ *
+ * Packets are sent from a source port in the range 49152 through 65535,
+ * on which the responses are received.
+ */
+func (i *Initiator) Connect(local net.IP, peer net.IP, port int) error {
+	t := &Transport{Port: port}
+	sender, err := t.Dial(local, peer)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	i.sender = sender
+	i.mu.Unlock()
+
+	i.wg.Add(1)
+	go i.receiveLoop(sender.conn)
+	i.Start(sender.Transmit)
+	return nil
+}
+
+/*
+ * Stop probing and close the socket opened by Connect
+ */
+func (i *Initiator) Close() error {
+	i.Stop()
+
+	i.mu.Lock()
+	sender := i.sender
+	i.sender = nil
+	i.mu.Unlock()
+
+	var err error
+	if sender != nil {
+		err = sender.Close()
+	}
+	i.wg.Wait()
+	return err
+}
+
+/*
+ * Number of responses received on the socket opened by Connect but not
+ * accepted, by reason
+ */
+func (i *Initiator) Drops() map[error]uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	drops := make(map[error]uint64, len(i.drops))
+	for reason, n := range i.drops {
+		drops[reason] = n
+	}
+	return drops
+}
+
+func (i *Initiator) countDrop(err error) {
+	i.mu.Lock()
+	i.drops[dropReason(err)]++
+	i.mu.Unlock()
+}
+
+func (i *Initiator) receiveLoop(conn *net.UDPConn) {
+	defer i.wg.Done()
+
+	buf := make([]byte, 1500)
+	backoff := time.Duration(0)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// Do not spin on an error that persists
+			i.countDrop(fmt.Errorf("%w: %v", ErrReceiveFailed, err))
+			backoff = readBackoff(backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		p, err := Decode(buf[:n])
+		if err == nil {
+			err = i.Receive(p)
+		}
+		if err != nil {
+			i.countDrop(err)
+		}
+	}
+}
+
+func (i *Initiator) transmitTimerExpired() {
+	i.mu.Lock()
+	if !i.running {
+		i.mu.Unlock()
+		return
+	}
+
+	p := i.controlPacket()
+	i.txTimer.Reset(applyJitter(microseconds(i.interval), i.detectMult))
+	tx := i.tx
+	i.mu.Unlock()
+
+	if tx != nil {
+		tx(p)
+	}
+}
+
+func (i *Initiator) detectTimerExpired() {
+	i.mu.Lock()
+	if !i.running {
+		i.mu.Unlock()
+		return
+	}
+
+	if remaining := i.detectionTime() - time.Since(i.lastRx); remaining > 0 {
+		i.detectTimer.Reset(remaining)
+		i.mu.Unlock()
+		return
+	}
+
+	old := i.state
+	if i.state == STATE_UP {
+		i.state = STATE_DOWN
+		i.diag = DIAG_TIME_EXPIRED
+	}
+	i.detectTimer.Reset(i.detectionTime())
+	state := i.state
+	i.mu.Unlock()
+
+	i.notify(old, state)
+}
+
+func (i *Initiator) notify(old BfdState, state BfdState) {
+	if old != state && i.onChange != nil {
+		i.onChange(i, old, state)
+	}
+}
@@ -0,0 +1,138 @@
+package bfd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReflect(t *testing.T) {
+	r := NewReflector()
+	r.MinRxInterval = 50000
+	if err := r.AddDiscriminator(0); !errors.Is(err, ErrZeroMyDiscriminator) {
+		t.Errorf("Zero target discriminator accepted, got %v", err)
+	}
+	r.AddDiscriminator(0x0a000001)
+
+	i := NewInitiator(7, 0x0a000001, 100000, 3, nil)
+	p := i.ControlPacket()
+	p.Poll = true
+
+	resp, err := r.Reflect(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.State != STATE_UP || !resp.Final || resp.Poll || resp.Demand {
+		t.Errorf("Response flags mismatch, got %#v", resp)
+	}
+	if resp.MyDiscriminator != 0x0a000001 || resp.YourDiscriminator != 7 {
+		t.Errorf("Discriminators not swapped, got %d and %d", resp.MyDiscriminator, resp.YourDiscriminator)
+	}
+	if resp.DetectMult != 3 || resp.DesiredMinTxInterval != 100000 || resp.RequiredMinRxInterval != 50000 {
+		t.Errorf("Response parameters mismatch, got %#v", resp)
+	}
+	if err := resp.Validate(); err != nil {
+		t.Errorf("Invalid response: %v", err)
+	}
+
+	r.SetAdminDown(true)
+	if resp, _ := r.Reflect(p); resp.State != STATE_ADMIN_DOWN {
+		t.Errorf("Administratively down reflector answered %v", resp.State)
+	}
+
+	r.RemoveDiscriminator(0x0a000001)
+	if _, err := r.Reflect(p); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Packet for removed discriminator reflected, got %v", err)
+	}
+}
+
+func TestInitiator(t *testing.T) {
+	r := NewReflector()
+	r.AddDiscriminator(100)
+
+	changes := make(chan BfdState, 4)
+	i := NewInitiator(7, 100, 10000, 3, func(i *Initiator, old BfdState, new BfdState) { changes <- new })
+
+	// Reflect packets while enabled
+	var mu sync.Mutex
+	reflecting := true
+	setReflecting := func(on bool) {
+		mu.Lock()
+		reflecting = on
+		mu.Unlock()
+	}
+	i.Start(func(p *BfdControlPacket) error {
+		if !p.Demand || p.YourDiscriminator != 100 {
+			t.Errorf("Initiator packet mismatch, got %#v", p)
+		}
+		mu.Lock()
+		on := reflecting
+		mu.Unlock()
+		if on {
+			resp, err := r.Reflect(p)
+			if err != nil {
+				return err
+			}
+			go i.Receive(resp)
+		}
+		return nil
+	})
+	defer i.Stop()
+
+	wait := func(expected BfdState) {
+		t.Helper()
+		select {
+		case state := <-changes:
+			if state != expected {
+				t.Fatalf("Expected state %v, got %v", expected, state)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for state %v", expected)
+		}
+	}
+
+	wait(STATE_UP)
+
+	setReflecting(false)
+	wait(STATE_DOWN)
+	if i.Diagnostic() != DIAG_TIME_EXPIRED {
+		t.Errorf("Diagnostic mismatch, got %v", i.Diagnostic())
+	}
+
+	setReflecting(true)
+	wait(STATE_UP)
+
+	r.SetAdminDown(true)
+	wait(STATE_DOWN)
+	if i.Diagnostic() != DIAG_NEIGHBOR_SIGNAL_DOWN {
+		t.Errorf("Diagnostic mismatch, got %v", i.Diagnostic())
+	}
+
+	resp := BfdControlPacketDefaults
+	resp.MyDiscriminator = 101
+	resp.YourDiscriminator = 7
+	if err := i.Receive(&resp); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Response from another target accepted, got %v", err)
+	}
+}
+
+func TestInitiatorDrops(t *testing.T) {
+	i := NewInitiator(7, 100, 10000, 3, nil)
+	r := NewReflector()
+
+	for _, err := range []error{syscall.ECONNREFUSED, syscall.ENOBUFS} {
+		i.countDrop(fmt.Errorf("%w: %v", ErrReceiveFailed, err))
+		r.countDrop(fmt.Errorf("%w: %v", ErrReceiveFailed, err), nil)
+	}
+	i.countDrop(&DecodeError{Reason: ErrZeroMyDiscriminator})
+
+	if drops := i.Drops(); drops[ErrReceiveFailed] != 2 || drops[ErrZeroMyDiscriminator] != 1 || len(drops) != 2 {
+		t.Errorf("Initiator drops not counted by reason, got %v", drops)
+	}
+	if drops := r.Drops(); drops[ErrReceiveFailed] != 2 || len(drops) != 1 {
+		t.Errorf("Reflector receive errors not counted together, got %v", drops)
+	}
+}
@@ -1,21 +1,61 @@
 package bfd
 
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+/*
+ * Session state variables (RFC 5880 section 6.8.1)
+ *
+ * Intervals are kept in microseconds, exactly as they are carried in
+ * a BfdControlPacket.
+ */
 type BfdStatus struct {
-	SessionState          int
-	RemoteSessionState    int
-	LocalDiscr            uint32
-	RemoteDiscr           uint32
-	LocalDiat             int
-	DesiredMinTxInterval  int
-	RequiredMinRxInterval int
-	RemoteMinRxInterval   int
-	DemandMode            bool
-	RemoteDemandMode      bool
-	DetectMult            uint8
-	AuthType              AuthenticationType
-	RcvAuthSeq            uint32
-	XmitAuthSeq           uint32
-	AuthSeqKnown          bool
+	SessionState               BfdState
+	RemoteSessionState         BfdState
+	LocalDiscr                 uint32
+	RemoteDiscr                uint32
+	LocalDiag                  BfdDiagnostic
+	DesiredMinTxInterval       time.Duration
+	RequiredMinRxInterval      time.Duration
+	RemoteMinRxInterval        time.Duration
+	DemandMode                 bool
+	RemoteDemandMode           bool
+	DetectMult                 uint8
+	AuthType                   AuthenticationType
+	RcvAuthSeq                 uint32
+	XmitAuthSeq                uint32
+	AuthSeqKnown               bool
+	RemoteDetectMult           uint8          // Last Detect Mult received
+	RemoteDesiredMinTxInterval time.Duration  // Last Desired Min TX Interval received
+	RemoteMinEchoRxInterval    time.Duration  // Last Required Min Echo RX Interval received
+	RequiredMinEchoRxInterval  time.Duration  // Zero unless the Echo function is in use
+	SessionType                BfdSessionType // Point-to-point unless multipoint (RFC 8562)
+}
+
+/*
+ * Initial values as required by RFC 5880 section 6.8.1
+ */
+var BfdStatusDefaults = BfdStatus{
+	SessionState:          STATE_DOWN,
+	RemoteSessionState:    STATE_DOWN,
+	LocalDiscr:            0,
+	RemoteDiscr:           0,
+	LocalDiag:             DIAG_NONE,
+	DesiredMinTxInterval:  1000000,
+	RequiredMinRxInterval: 1000000,
+	RemoteMinRxInterval:   1,
+	DemandMode:            false,
+	RemoteDemandMode:      false,
+	DetectMult:            3,
+	AuthType:              BFD_AUTH_TYPE_RESERVED,
+	RcvAuthSeq:            0,
+	XmitAuthSeq:           0,
+	AuthSeqKnown:          false,
+	SessionType:           SESSION_TYPE_POINT_TO_POINT,
 }
 
 /* State Machine
@@ -38,3 +78,288 @@ type BfdStatus struct {
        +--->|      | INIT, UP             |      |<---+
             +------+                      +------+
 */
+
+/*
+ * Called after every change of the local session state
+ */
+type StateChangeFunc func(s *Session, old BfdState, new BfdState)
+
+/*
+ * A single BFD session, driven by received control packets and
+ * detection timer expirations
+ */
+type Session struct {
+	mu       sync.Mutex
+	status   BfdStatus
+	onChange StateChangeFunc
+
+	running     bool
+	tx          TransmitFunc
+	txTimer     *time.Timer
+	detectTimer *time.Timer
+	lastRx      time.Time
+
+	polling     bool
+	pollBefore  pollParams
+	pollSent    pollParams
+	pollWaiters []chan bool
+
+	echoTx                   TransmitFunc
+	echoDesiredMinTxInterval time.Duration
+	echoTimer                *time.Timer
+	echoLastRx               time.Time
+	echoWasActive            bool
+
+	keyChain        *KeyChain
+	authSigned      bool
+	authLastContent []byte
+	authOptimized   bool // Peer sends Optimized authentication
+	authFallback    bool // Peer sends plain Meticulous authentication
+	isaacTx         *isaacStream
+	isaacRx         *isaacStream
+}
+
+/*
+ * Create a session from the given initial state variables
+ */
+func NewSession(status BfdStatus, fn StateChangeFunc) *Session {
+	if status.XmitAuthSeq == 0 {
+		status.XmitAuthSeq = randomSequence()
+	}
+	if status.SessionState == STATE_DOWN {
+		status.SessionState = enabledState(status.SessionType)
+	}
+
+	return &Session{
+		status:   status,
+		onChange: fn,
+	}
+}
+
+/*
+ * Initial authentication sequence number, which must be unpredictable
+ * (RFC 5880 section 6.8.1)
+ */
+func randomSequence() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// The system has no entropy to offer, nothing would be secure
+		panic(err)
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+/*
+ * Return a copy of the session state variables
+ */
+func (s *Session) Status() BfdStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+/*
+ * Return the local session state
+ */
+func (s *Session) State() BfdState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status.SessionState
+}
+
+/*
+ * Update the session from a received control packet (RFC 5880 section 6.8.6)
+ *
+ * The packet is expected to have already passed Validate, the
+ * remaining checks depend on the session configuration.
+ */
+func (s *Session) Receive(p *BfdControlPacket) error {
+	s.mu.Lock()
+	if err := s.checkMultipoint(p); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if err := s.authenticate(p); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	old := s.status.SessionState
+	before := s.params()
+	s.receive(p)
+	s.checkPoll(before)
+	s.restartDetectionTimer()
+	state := s.status.SessionState
+
+	// Answer a Poll right away, without regard to the transmit timer
+	var final *BfdControlPacket
+	if p.Poll && state != STATE_ADMIN_DOWN && s.running && !s.multipoint() {
+		final = s.controlPacket()
+		final.Poll = false
+		final.Final = true
+		if s.sign(final) != nil {
+			final = nil
+		}
+	}
+	tx := s.tx
+	s.mu.Unlock()
+
+	if final != nil && tx != nil {
+		tx(final)
+	}
+
+	s.notify(old, state)
+	return nil
+}
+
+func (s *Session) receive(p *BfdControlPacket) {
+	s.status.RemoteDiscr = p.MyDiscriminator
+	s.status.RemoteSessionState = p.State
+	s.status.RemoteDemandMode = p.Demand
+	s.status.RemoteMinRxInterval = p.RequiredMinRxInterval
+	s.status.RemoteDesiredMinTxInterval = p.DesiredMinTxInterval
+	s.status.RemoteMinEchoRxInterval = p.RequiredMinEchoRxInterval
+	s.status.RemoteDetectMult = p.DetectMult
+
+	if p.Final {
+		s.receiveFinal()
+	}
+
+	if s.status.SessionState == STATE_ADMIN_DOWN {
+		return
+	}
+
+	if s.status.SessionType == SESSION_TYPE_MULTIPOINT_TAIL {
+		s.receiveTail(p)
+		return
+	}
+
+	if p.State == STATE_ADMIN_DOWN {
+		if s.status.SessionState != STATE_DOWN {
+			s.status.LocalDiag = DIAG_NEIGHBOR_SIGNAL_DOWN
+			s.status.SessionState = STATE_DOWN
+		}
+		return
+	}
+
+	switch s.status.SessionState {
+	case STATE_DOWN:
+		switch p.State {
+		case STATE_DOWN:
+			s.status.SessionState = STATE_INIT
+		case STATE_INIT:
+			s.status.SessionState = STATE_UP
+		}
+	case STATE_INIT:
+		switch p.State {
+		case STATE_INIT, STATE_UP:
+			s.status.SessionState = STATE_UP
+		}
+	case STATE_UP:
+		if p.State == STATE_DOWN {
+			s.status.LocalDiag = DIAG_NEIGHBOR_SIGNAL_DOWN
+			s.status.SessionState = STATE_DOWN
+		}
+	}
+}
+
+/*
+ * Take the session down after the Detection Time has passed without
+ * receiving a valid control packet (RFC 5880 section 6.8.4)
+ */
+func (s *Session) DetectionTimeExpired() {
+	s.mu.Lock()
+	old := s.status.SessionState
+	s.expire(DIAG_TIME_EXPIRED)
+	s.checkPoll(s.params())
+	state := s.status.SessionState
+	s.mu.Unlock()
+
+	s.notify(old, state)
+}
+
+func (s *Session) expire(diag BfdDiagnostic) {
+	switch s.status.SessionState {
+	case STATE_INIT, STATE_UP:
+		s.status.LocalDiag = diag
+		s.status.SessionState = STATE_DOWN
+	}
+	s.status.RemoteDiscr = 0
+}
+
+/*
+ * Administratively disable the session (RFC 5880 section 6.8.16)
+ */
+func (s *Session) SetAdminDown(diag BfdDiagnostic) {
+	s.mu.Lock()
+	old := s.status.SessionState
+	s.status.SessionState = STATE_ADMIN_DOWN
+	s.status.LocalDiag = diag
+	s.checkPoll(s.params())
+	s.mu.Unlock()
+
+	s.notify(old, STATE_ADMIN_DOWN)
+}
+
+/*
+ * Re-enable an administratively disabled session
+ */
+func (s *Session) SetAdminUp() {
+	s.mu.Lock()
+	old := s.status.SessionState
+	if old == STATE_ADMIN_DOWN {
+		s.status.SessionState = enabledState(s.status.SessionType)
+		s.status.LocalDiag = DIAG_NONE
+	}
+	state := s.status.SessionState
+	s.mu.Unlock()
+
+	s.notify(old, state)
+}
+
+/*
+ * Build the next control packet to transmit (RFC 5880 section 6.8.7)
+ *
+ * The authentication section is left out: it is only added when the
+ * packet is sent, so looking at the packet does not use up sequence
+ * numbers.
+ */
+func (s *Session) ControlPacket() *BfdControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.controlPacket()
+}
+
+func (s *Session) controlPacket() *BfdControlPacket {
+	p := BfdControlPacketDefaults
+
+	p.Diagnostic = s.status.LocalDiag
+	p.State = s.status.SessionState
+	p.Poll = s.polling
+	p.Demand = s.status.DemandMode && s.status.SessionState == STATE_UP && s.status.RemoteSessionState == STATE_UP
+	p.DetectMult = s.status.DetectMult
+	p.MyDiscriminator = s.status.LocalDiscr
+	p.YourDiscriminator = s.status.RemoteDiscr
+	p.DesiredMinTxInterval = s.desiredMinTxInterval()
+	p.RequiredMinRxInterval = s.requiredMinRxInterval()
+	p.RequiredMinEchoRxInterval = s.status.RequiredMinEchoRxInterval
+
+	// Heads expect nothing back from their tails (RFC 8562)
+	if s.status.SessionType == SESSION_TYPE_MULTIPOINT_HEAD {
+		p.Multipoint = true
+		p.YourDiscriminator = 0
+		p.RequiredMinRxInterval = 0
+		p.RequiredMinEchoRxInterval = 0
+	}
+
+	return &p
+}
+
+func (s *Session) notify(old BfdState, state BfdState) {
+	if old != state && s.onChange != nil {
+		s.onChange(s, old, state)
+	}
+}
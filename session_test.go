@@ -0,0 +1,117 @@
+package bfd
+
+import (
+	"testing"
+)
+
+type bfdSessionTransitionTestSet struct {
+	Name     string
+	Local    BfdState
+	Event    string // "recv", "timer", "admin-down" or "admin-up"
+	Remote   BfdState
+	Expected BfdState
+	Diag     BfdDiagnostic
+}
+
+/*
+ * One entry per edge of the RFC 5880 state diagram, plus local
+ * administrative control
+ */
+var sessionTransitionTests = []bfdSessionTransitionTestSet{
+	{Name: "Down: recv Down", Local: STATE_DOWN, Event: "recv", Remote: STATE_DOWN, Expected: STATE_INIT, Diag: DIAG_NONE},
+	{Name: "Down: recv Init", Local: STATE_DOWN, Event: "recv", Remote: STATE_INIT, Expected: STATE_UP, Diag: DIAG_NONE},
+	{Name: "Down: recv Up", Local: STATE_DOWN, Event: "recv", Remote: STATE_UP, Expected: STATE_DOWN, Diag: DIAG_NONE},
+	{Name: "Down: recv AdminDown", Local: STATE_DOWN, Event: "recv", Remote: STATE_ADMIN_DOWN, Expected: STATE_DOWN, Diag: DIAG_NONE},
+	{Name: "Down: timer", Local: STATE_DOWN, Event: "timer", Expected: STATE_DOWN, Diag: DIAG_NONE},
+
+	{Name: "Init: recv Down", Local: STATE_INIT, Event: "recv", Remote: STATE_DOWN, Expected: STATE_INIT, Diag: DIAG_NONE},
+	{Name: "Init: recv Init", Local: STATE_INIT, Event: "recv", Remote: STATE_INIT, Expected: STATE_UP, Diag: DIAG_NONE},
+	{Name: "Init: recv Up", Local: STATE_INIT, Event: "recv", Remote: STATE_UP, Expected: STATE_UP, Diag: DIAG_NONE},
+	{Name: "Init: recv AdminDown", Local: STATE_INIT, Event: "recv", Remote: STATE_ADMIN_DOWN, Expected: STATE_DOWN, Diag: DIAG_NEIGHBOR_SIGNAL_DOWN},
+	{Name: "Init: timer", Local: STATE_INIT, Event: "timer", Expected: STATE_DOWN, Diag: DIAG_TIME_EXPIRED},
+
+	{Name: "Up: recv Down", Local: STATE_UP, Event: "recv", Remote: STATE_DOWN, Expected: STATE_DOWN, Diag: DIAG_NEIGHBOR_SIGNAL_DOWN},
+	{Name: "Up: recv Init", Local: STATE_UP, Event: "recv", Remote: STATE_INIT, Expected: STATE_UP, Diag: DIAG_NONE},
+	{Name: "Up: recv Up", Local: STATE_UP, Event: "recv", Remote: STATE_UP, Expected: STATE_UP, Diag: DIAG_NONE},
+	{Name: "Up: recv AdminDown", Local: STATE_UP, Event: "recv", Remote: STATE_ADMIN_DOWN, Expected: STATE_DOWN, Diag: DIAG_NEIGHBOR_SIGNAL_DOWN},
+	{Name: "Up: timer", Local: STATE_UP, Event: "timer", Expected: STATE_DOWN, Diag: DIAG_TIME_EXPIRED},
+
+	{Name: "AdminDown: recv Down", Local: STATE_ADMIN_DOWN, Event: "recv", Remote: STATE_DOWN, Expected: STATE_ADMIN_DOWN, Diag: DIAG_NONE},
+	{Name: "AdminDown: recv Init", Local: STATE_ADMIN_DOWN, Event: "recv", Remote: STATE_INIT, Expected: STATE_ADMIN_DOWN, Diag: DIAG_NONE},
+	{Name: "AdminDown: timer", Local: STATE_ADMIN_DOWN, Event: "timer", Expected: STATE_ADMIN_DOWN, Diag: DIAG_NONE},
+	{Name: "AdminDown: admin up", Local: STATE_ADMIN_DOWN, Event: "admin-up", Expected: STATE_DOWN, Diag: DIAG_NONE},
+	{Name: "Up: admin down", Local: STATE_UP, Event: "admin-down", Expected: STATE_ADMIN_DOWN, Diag: DIAG_ADMIN_DOWN},
+}
+
+func TestSessionTransitions(t *testing.T) {
+	for _, e := range sessionTransitionTests {
+		var changes []BfdState
+
+		status := BfdStatusDefaults
+		status.LocalDiscr = 1
+		status.SessionState = e.Local
+		s := NewSession(status, func(s *Session, old BfdState, new BfdState) {
+			changes = append(changes, new)
+		})
+
+		switch e.Event {
+		case "recv":
+			p := BfdControlPacketDefaults
+			p.State = e.Remote
+			p.MyDiscriminator = 25
+			p.YourDiscriminator = 1
+			s.Receive(&p)
+		case "timer":
+			s.DetectionTimeExpired()
+		case "admin-down":
+			s.SetAdminDown(DIAG_ADMIN_DOWN)
+		case "admin-up":
+			s.SetAdminUp()
+		}
+
+		got := s.Status()
+		if got.SessionState != e.Expected {
+			t.Errorf("State mismatch for test '%s', expected %d, got %d", e.Name, e.Expected, got.SessionState)
+		}
+		if got.LocalDiag != e.Diag {
+			t.Errorf("Diagnostic mismatch for test '%s', expected %d, got %d", e.Name, e.Diag, got.LocalDiag)
+		}
+
+		if e.Local != e.Expected && (len(changes) != 1 || changes[0] != e.Expected) {
+			t.Errorf("State change callback mismatch for test '%s', got %v", e.Name, changes)
+		}
+		if e.Local == e.Expected && len(changes) != 0 {
+			t.Errorf("Unexpected state change callback for test '%s', got %v", e.Name, changes)
+		}
+	}
+}
+
+/*
+ * Two sessions exchanging packets must come up from Down
+ */
+func TestSessionThreeWayHandshake(t *testing.T) {
+	a := BfdStatusDefaults
+	a.LocalDiscr = 1
+	b := BfdStatusDefaults
+	b.LocalDiscr = 2
+
+	sa := NewSession(a, nil)
+	sb := NewSession(b, nil)
+
+	for i := 0; i < 3; i++ {
+		sb.Receive(sa.ControlPacket())
+		sa.Receive(sb.ControlPacket())
+	}
+
+	if sa.State() != STATE_UP || sb.State() != STATE_UP {
+		t.Errorf("Sessions did not come up, got %d and %d", sa.State(), sb.State())
+	}
+	if sa.Status().RemoteDiscr != 2 || sb.Status().RemoteDiscr != 1 {
+		t.Errorf("Remote discriminators not learned")
+	}
+
+	sa.DetectionTimeExpired()
+	if sa.Status().RemoteDiscr != 0 {
+		t.Errorf("Remote discriminator not reset after detection timeout")
+	}
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package bfd
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+)
+
+/*
+ * Ask for the TTL / Hop Limit and destination address of every
+ * received packet
+ */
+func controlReceive(network string, address string, c syscall.RawConn) error {
+	var err error
+
+	cerr := c.Control(func(fd uintptr) {
+		if network == "udp6" {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+			}
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+			}
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+/*
+ * Send with a TTL / Hop Limit of 255, to multicast groups as well
+ */
+func controlTransmit(network string, address string, c syscall.RawConn) error {
+	var err error
+
+	cerr := c.Control(func(fd uintptr) {
+		if network == "udp6" {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, BFD_TTL)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, BFD_TTL)
+			}
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, BFD_TTL)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, BFD_TTL)
+			}
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+/*
+ * Add a listening socket to a multicast group
+ */
+func joinGroup(conn *net.UDPConn, group net.IP, ifIndex int) error {
+	var err error
+
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	cerr := c.Control(func(fd uintptr) {
+		if group.To4() != nil {
+			mreq := &syscall.IPMreqn{Ifindex: int32(ifIndex)}
+			copy(mreq.Multiaddr[:], group.To4())
+			err = syscall.SetsockoptIPMreqn(int(fd), syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq)
+		} else {
+			mreq := &syscall.IPv6Mreq{Interface: uint32(ifIndex)}
+			copy(mreq.Multiaddr[:], group.To16())
+			err = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+/*
+ * Fill in the TTL, destination address and interface of a received
+ * packet from its ancillary data
+ */
+func parseControlMessages(oob []byte, r *ReceivedPacket) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL && len(m.Data) >= 4:
+			r.TTL = int(binary.NativeEndian.Uint32(m.Data))
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO && len(m.Data) >= syscall.SizeofInet4Pktinfo:
+			// struct in_pktinfo { int ifindex; in_addr spec_dst; in_addr addr; }
+			r.IfIndex = int(binary.NativeEndian.Uint32(m.Data[0:4]))
+			r.Local = net.IP(append([]byte{}, m.Data[8:12]...))
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_HOPLIMIT && len(m.Data) >= 4:
+			r.TTL = int(binary.NativeEndian.Uint32(m.Data))
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_PKTINFO && len(m.Data) >= syscall.SizeofInet6Pktinfo:
+			// struct in6_pktinfo { in6_addr addr; int ifindex; }
+			r.Local = net.IP(append([]byte{}, m.Data[0:16]...))
+			r.IfIndex = int(binary.NativeEndian.Uint32(m.Data[16:20]))
+		}
+	}
+}
+
+/*
+ * Raw socket on which EchoSender writes complete IPv4 packets
+ */
+func openEchoSocket() (int, error) {
+	return syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+}
+
+func (s *EchoSender) send(b []byte) error {
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], s.peer)
+
+	return syscall.Sendto(s.fd, b, 0, sa)
+}
+
+func (s *EchoSender) Close() error {
+	return syscall.Close(s.fd)
+}
+
+/*
+ * Socket filters passing UDP to port 6784, in IPv4 packets that are not
+ * fragments and IPv6 packets without extension headers
+ */
+var (
+	microFilterIPv4 = []syscall.SockFilter{
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, 9),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.IPPROTO_UDP, 0, 6),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 6),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, 0x1fff, 4, 0),
+		*syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_B|syscall.BPF_MSH, 0),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 2),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, BFD_PORT_MICRO, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0xffff),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+	}
+	microFilterIPv6 = []syscall.SockFilter{
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, 6),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.IPPROTO_UDP, 0, 3),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 42),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, BFD_PORT_MICRO, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0xffff),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+	}
+)
+
+/*
+ * Open a packet socket for micro-BFD packets on a member link
+ *
+ * The socket receives the micro-BFD packets of the link, in the address
+ * family of local and peer. It also adds BFD_MICRO_MAC to the link's
+ * multicast filter, so that packets from the peer are received for as
+ * long as it is open.
+ */
+func DialMicro(ifIndex int, local net.IP, peer net.IP) (*MicroSender, error) {
+	if (local.To4() == nil) != (peer.To4() == nil) {
+		return nil, ErrAddressMismatch
+	}
+
+	protocol, filter := uint16(syscall.ETH_P_IPV6), microFilterIPv6
+	if local.To4() != nil {
+		protocol, filter = syscall.ETH_P_IP, microFilterIPv4
+	}
+	protocol = protocol<<8 | protocol>>8 // Network byte order
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter before binding, so that no other traffic is queued
+	if err := syscall.AttachLsf(fd, filter); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: ifIndex}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// struct packet_mreq
+	mreq := make([]byte, 16)
+	binary.NativeEndian.PutUint32(mreq[0:4], uint32(ifIndex))
+	binary.NativeEndian.PutUint16(mreq[4:6], syscall.PACKET_MR_MULTICAST)
+	binary.NativeEndian.PutUint16(mreq[6:8], uint16(len(BFD_MICRO_MAC)))
+	copy(mreq[8:], BFD_MICRO_MAC)
+	if err := syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mreq)); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	if local.To4() != nil {
+		local, peer = local.To4(), peer.To4()
+	}
+	return newMicroSender(os.NewFile(uintptr(fd), "micro-bfd"), ifIndex, local, peer), nil
+}
+
+func (s *MicroSender) send(b []byte) error {
+	protocol := uint16(syscall.ETH_P_IPV6)
+	if s.local.To4() != nil {
+		protocol = syscall.ETH_P_IP
+	}
+
+	sa := &syscall.SockaddrLinklayer{
+		Protocol: protocol<<8 | protocol>>8, // Network byte order
+		Ifindex:  s.ifIndex,
+		Halen:    uint8(len(BFD_MICRO_MAC)),
+	}
+	copy(sa.Addr[:], BFD_MICRO_MAC)
+
+	c, err := s.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = c.Write(func(fd uintptr) bool {
+		serr = syscall.Sendto(int(fd), b, 0, sa)
+		return serr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+/*
+ * Read a packet received on the member link, reporting false for those
+ * sent by this host and any queued from other links before binding
+ */
+func (s *MicroSender) recv(buf []byte) (int, bool, error) {
+	c, err := s.file.SyscallConn()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var n int
+	var from syscall.Sockaddr
+	var rerr error
+	err = c.Read(func(fd uintptr) bool {
+		n, from, rerr = syscall.Recvfrom(int(fd), buf, 0)
+		return rerr != syscall.EAGAIN
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if rerr != nil {
+		return 0, false, rerr
+	}
+
+	sa, ok := from.(*syscall.SockaddrLinklayer)
+	return n, ok && sa.Ifindex == s.ifIndex && sa.Pkttype != syscall.PACKET_OUTGOING, nil
+}
+
+/*
+ * Open a packet socket sending labeled packets on the outgoing
+ * interface of an LSP
+ */
+func DialLsp(lsp Lsp) (*LspSender, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Protocol zero: the socket only sends, and receives nothing
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Ifindex: lsp.IfIndex}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	if lsp.Local.To4() != nil {
+		lsp.Local = lsp.Local.To4()
+	}
+	return newLspSender(fd, lsp), nil
+}
+
+func (s *LspSender) send(b []byte) error {
+	const protocol = 0x8847 // ETH_P_MPLS_UC
+
+	sa := &syscall.SockaddrLinklayer{
+		Protocol: protocol<<8&0xff00 | protocol>>8, // Network byte order
+		Ifindex:  s.lsp.IfIndex,
+		Halen:    uint8(len(s.lsp.NextHop)),
+	}
+	copy(sa.Addr[:], s.lsp.NextHop)
+
+	return syscall.Sendto(s.fd, b, 0, sa)
+}
+
+func (s *LspSender) Close() error {
+	return syscall.Close(s.fd)
+}
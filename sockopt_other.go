@@ -0,0 +1,62 @@
+//go:build !linux
+
+package bfd
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errUnsupportedPlatform = errors.New("BFD transport is not supported on this platform!")
+
+func controlReceive(network string, address string, c syscall.RawConn) error {
+	return errUnsupportedPlatform
+}
+
+func controlTransmit(network string, address string, c syscall.RawConn) error {
+	return errUnsupportedPlatform
+}
+
+func joinGroup(conn *net.UDPConn, group net.IP, ifIndex int) error {
+	return errUnsupportedPlatform
+}
+
+func parseControlMessages(oob []byte, r *ReceivedPacket) {
+}
+
+func openEchoSocket() (int, error) {
+	return -1, ErrEchoUnsupported
+}
+
+func (s *EchoSender) send(b []byte) error {
+	return ErrEchoUnsupported
+}
+
+func (s *EchoSender) Close() error {
+	return nil
+}
+
+func DialMicro(ifIndex int, local net.IP, peer net.IP) (*MicroSender, error) {
+	return nil, errUnsupportedPlatform
+}
+
+func (s *MicroSender) send(b []byte) error {
+	return errUnsupportedPlatform
+}
+
+func (s *MicroSender) recv(buf []byte) (int, bool, error) {
+	return 0, false, errUnsupportedPlatform
+}
+
+func DialLsp(lsp Lsp) (*LspSender, error) {
+	return nil, errUnsupportedPlatform
+}
+
+func (s *LspSender) send(b []byte) error {
+	return errUnsupportedPlatform
+}
+
+func (s *LspSender) Close() error {
+	return nil
+}
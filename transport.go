@@ -0,0 +1,298 @@
+package bfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	BFD_PORT_SINGLE_HOP = 3784  // Control packets, RFC 5881
+	BFD_SOURCE_PORT_MIN = 49152 // Lowest allowed source port
+	BFD_SOURCE_PORT_MAX = 65535 // Highest allowed source port
+	BFD_TTL             = 255   // TTL / Hop Limit for transmitted packets
+)
+
+/*
+ * Bounds of the pause after a failed read, doubling while reads keep
+ * failing
+ */
+const (
+	readBackoffMin = 5 * time.Millisecond
+	readBackoffMax = 100 * time.Millisecond
+)
+
+/*
+ * A decoded control packet along with where it came from
+ */
+type ReceivedPacket struct {
+	Packet  *BfdControlPacket
+	Peer    *net.UDPAddr // Source address and port
+	Local   net.IP       // Destination address of the packet
+	IfIndex int          // Receiving interface, zero if unknown
+	TTL     int          // Received TTL / Hop Limit
+	VNI     uint32       // Overlay network identifier, zero if none
+
+	conn *net.UDPConn
+}
+
+/*
+ * Send a packet back to where r came from, through the socket it was
+ * received on
+ */
+func (r *ReceivedPacket) Reply(p *BfdControlPacket) error {
+	if r.conn == nil {
+		return net.ErrClosed
+	}
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.conn.WriteToUDP(b, r.Peer)
+	return err
+}
+
+/*
+ * Called for every packet that passed decoding and the TTL check
+ */
+type PacketHandler func(r *ReceivedPacket)
+
+/*
+ * Called for every packet that was discarded by the transport, and for
+ * failed reads with a nil peer
+ */
+type DropHandler func(err error, peer *net.UDPAddr)
+
+/*
+ * UDP transport for BFD control packets over IPv4 and IPv6
+ */
+type Transport struct {
+	Port    int           // Destination port to listen and send on
+	MinTTL  int           // Lowest TTL / Hop Limit accepted
+	Handler PacketHandler // Receives valid packets
+	Drop    DropHandler   // Optional, notified of discarded packets
+
+	// Extracts the control packet from a datagram, for encapsulations
+	decode func(data []byte, r *ReceivedPacket) error
+
+	mu    sync.Mutex
+	conns []*net.UDPConn
+	wg    sync.WaitGroup
+}
+
+/*
+ * Create a single hop transport (RFC 5881), only accepting packets
+ * sent with a TTL / Hop Limit of 255
+ */
+func NewTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_SINGLE_HOP,
+		MinTTL:  BFD_TTL,
+		Handler: handler,
+	}
+}
+
+/*
+ * Start listening on all IPv4 and IPv6 addresses
+ */
+func (t *Transport) Listen() error {
+	for _, network := range []string{"udp4", "udp6"} {
+		lc := net.ListenConfig{Control: controlReceive}
+		c, err := lc.ListenPacket(context.Background(), network, ":"+strconv.Itoa(t.Port))
+		if err != nil {
+			t.Close()
+			return err
+		}
+
+		conn := c.(*net.UDPConn)
+		t.mu.Lock()
+		t.conns = append(t.conns, conn)
+		t.mu.Unlock()
+
+		t.wg.Add(1)
+		go t.receiveLoop(conn)
+	}
+
+	return nil
+}
+
+/*
+ * Stop listening and wait for the receive loops to finish
+ */
+func (t *Transport) Close() error {
+	var err error
+
+	t.mu.Lock()
+	for _, c := range t.conns {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.conns = nil
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+/*
+ * Receive packets sent to a multicast group, on the given interface or
+ * one chosen by the system when ifIndex is zero. Listen must have been
+ * called first.
+ */
+func (t *Transport) JoinGroup(group net.IP, ifIndex int) error {
+	if !group.IsMulticast() {
+		return ErrAddressMismatch
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range t.conns {
+		if (c.LocalAddr().(*net.UDPAddr).IP.To4() == nil) == (group.To4() == nil) {
+			return joinGroup(c, group, ifIndex)
+		}
+	}
+	return net.ErrClosed
+}
+
+func (t *Transport) receiveLoop(conn *net.UDPConn) {
+	defer t.wg.Done()
+
+	buf := make([]byte, 1500)
+	oob := make([]byte, 256)
+	backoff := time.Duration(0)
+
+	for {
+		n, oobn, _, peer, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// Do not spin on an error that persists
+			t.drop(fmt.Errorf("%w: %v", ErrReceiveFailed, err), nil)
+			backoff = readBackoff(backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		r := &ReceivedPacket{Peer: peer, TTL: -1, conn: conn}
+		parseControlMessages(oob[:oobn], r)
+
+		if r.TTL < t.MinTTL {
+			t.drop(ErrInvalidTTL, peer)
+			continue
+		}
+
+		if t.decode != nil {
+			err = t.decode(buf[:n], r)
+		} else {
+			r.Packet, err = Decode(buf[:n])
+		}
+		if err != nil {
+			t.drop(err, peer)
+			continue
+		}
+
+		if t.Handler != nil {
+			t.Handler(r)
+		}
+	}
+}
+
+/*
+ * Pause to take after a failed read, given the previous one
+ */
+func readBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < readBackoffMin {
+		return readBackoffMin
+	}
+	if backoff > readBackoffMax {
+		return readBackoffMax
+	}
+	return backoff
+}
+
+func (t *Transport) drop(err error, peer *net.UDPAddr) {
+	if t.Drop != nil {
+		t.Drop(err, peer)
+	}
+}
+
+/*
+ * Open a socket for transmitting control packets to a peer
+ *
+ * The socket is bound to the local address (which may be nil) and a
+ * source port in the range 49152 through 65535, and sends with a TTL /
+ * Hop Limit of 255.
+ */
+func (t *Transport) Dial(local net.IP, peer net.IP) (*Sender, error) {
+	var err error
+	var conn net.Conn
+
+	network := "udp4"
+	if peer.To4() == nil {
+		network = "udp6"
+	}
+	raddr := &net.UDPAddr{IP: peer, Port: t.Port}
+
+	// Pick source ports at random until a free one is found
+	span := BFD_SOURCE_PORT_MAX - BFD_SOURCE_PORT_MIN + 1
+	for i := 0; i < 64; i++ {
+		d := net.Dialer{
+			LocalAddr: &net.UDPAddr{IP: local, Port: BFD_SOURCE_PORT_MIN + rand.Intn(span)},
+			Control:   controlTransmit,
+		}
+		conn, err = d.Dial(network, raddr.String())
+		if err == nil {
+			return &Sender{conn: conn.(*net.UDPConn)}, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+/*
+ * Transmits control packets to a single peer
+ */
+type Sender struct {
+	conn *net.UDPConn
+}
+
+/*
+ * Send a control packet, usable as a TransmitFunc
+ */
+func (s *Sender) Transmit(p *BfdControlPacket) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(b)
+	return err
+}
+
+/*
+ * Local address and source port the sender is bound to
+ */
+func (s *Sender) LocalAddr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (s *Sender) Close() error {
+	return s.conn.Close()
+}
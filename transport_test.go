@@ -0,0 +1,384 @@
+//go:build linux
+
+package bfd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+ * Find a UDP port which is currently free on both address families
+ */
+func freeUDPPort(t *testing.T) int {
+	for i := 0; i < 16; i++ {
+		c4, err := net.ListenUDP("udp4", &net.UDPAddr{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := c4.LocalAddr().(*net.UDPAddr).Port
+		c6, err := net.ListenUDP("udp6", &net.UDPAddr{Port: port})
+		c4.Close()
+		if err == nil {
+			c6.Close()
+			return port
+		}
+	}
+
+	t.Fatal("No free UDP port found")
+	return 0
+}
+
+func TestTransportSingleHop(t *testing.T) {
+	received := make(chan *ReceivedPacket, 4)
+	dropped := make(chan error, 4)
+
+	tr := NewTransport(func(r *ReceivedPacket) { received <- r })
+	tr.Drop = func(err error, peer *net.UDPAddr) { dropped <- err }
+	tr.Port = freeUDPPort(t)
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = 1
+
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		s, err := tr.Dial(nil, net.ParseIP(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port := s.LocalAddr().Port; port < BFD_SOURCE_PORT_MIN || port > BFD_SOURCE_PORT_MAX {
+			t.Errorf("Source port %d out of range", port)
+		}
+		if err := s.Transmit(&p); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case r := <-received:
+			if r.TTL != BFD_TTL {
+				t.Errorf("TTL mismatch for %s, expected %d, got %d", addr, BFD_TTL, r.TTL)
+			}
+			if !r.Local.Equal(net.ParseIP(addr)) {
+				t.Errorf("Local address mismatch for %s, got %s", addr, r.Local)
+			}
+			if r.Peer.Port != s.LocalAddr().Port || r.Packet.MyDiscriminator != 1 {
+				t.Errorf("Received packet mismatch for %s, got %#v", addr, r)
+			}
+		case err := <-dropped:
+			t.Errorf("Packet to %s dropped: %v", addr, err)
+		case <-time.After(time.Second):
+			t.Errorf("Packet to %s not received", addr)
+		}
+		s.Close()
+	}
+
+	// Plain sockets send with the default TTL and must be discarded
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tr.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(p.Marshal())
+
+	select {
+	case r := <-received:
+		t.Errorf("Packet with TTL %d not dropped", r.TTL)
+	case err := <-dropped:
+		if !errors.Is(err, ErrInvalidTTL) {
+			t.Errorf("Drop reason mismatch, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Packet with low TTL not reported")
+	}
+}
+
+/*
+ * Echo packets sent over loopback come straight back (needs CAP_NET_RAW)
+ */
+func TestEchoTransport(t *testing.T) {
+	received := make(chan *ReceivedPacket, 1)
+	local := net.ParseIP("127.0.0.1")
+
+	sender, err := DialEcho(local, local)
+	if err != nil {
+		t.Skip("Raw sockets unavailable:", err)
+	}
+	defer sender.Close()
+
+	tr := NewEchoTransport(func(r *ReceivedPacket) { received <- r })
+	if err := tr.Listen(); err != nil {
+		t.Skip("Echo port unavailable:", err)
+	}
+	defer tr.Close()
+
+	p := BfdControlPacketDefaults
+	p.State = STATE_UP
+	p.MyDiscriminator = 5
+	p.YourDiscriminator = 5
+	if err := sender.Transmit(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-received:
+		if r.Packet.MyDiscriminator != 5 || r.Peer.Port != int(sender.srcPort) {
+			t.Errorf("Echo packet mismatch, got %#v", r)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Echo packet not received")
+	}
+}
+
+func TestSeamlessBFD(t *testing.T) {
+	r := NewReflector()
+	r.Transport().Port = freeUDPPort(t)
+	r.AddDiscriminator(0x7f000001)
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		up := make(chan bool, 1)
+		i := NewInitiator(1, 0x7f000001, 10000, 3, func(i *Initiator, old BfdState, new BfdState) {
+			if new == STATE_UP {
+				up <- true
+			}
+		})
+		if err := i.Connect(nil, net.ParseIP(addr), r.Transport().Port); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-up:
+		case <-time.After(time.Second):
+			t.Errorf("Reflector at %s not reachable, drops %v", addr, r.Drops())
+		}
+		i.Close()
+	}
+
+	i := NewInitiator(1, 0x7f000002, 10000, 3, nil)
+	i.Connect(nil, net.ParseIP("127.0.0.1"), r.Transport().Port)
+	time.Sleep(50 * time.Millisecond)
+	i.Close()
+	if i.State() != STATE_DOWN || r.Drops()[ErrUnknownDiscriminator] == 0 {
+		t.Errorf("Unknown target discriminator reflected")
+	}
+}
+
+/*
+ * Sessions between two VTEP addresses on loopback, one pair per VNI
+ */
+func TestVxlanSessions(t *testing.T) {
+	local := net.ParseIP("127.0.0.1")
+	peer := net.ParseIP("127.0.0.2")
+
+	m := NewVxlanManager(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, 100)
+	m.Transport().Port = freeUDPPort(t)
+	if err := m.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	var sessions []*Session
+	for _, vni := range []uint32{100, 200} {
+		a, err := m.AddVNISession(peer, local, vni, BfdStatusDefaults, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := m.AddVNISession(local, peer, vni, BfdStatusDefaults, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, a, b)
+	}
+	if _, err := m.AddSession(peer, local, 0, BfdStatusDefaults, nil); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("Second session on the management VNI accepted, got %v", err)
+	}
+	if _, err := NewManager().AddVNISession(peer, local, 100, BfdStatusDefaults, nil); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("VNI session added outside an overlay manager, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range sessions {
+		for s.State() != STATE_UP {
+			if time.Now().After(deadline) {
+				t.Fatalf("Sessions did not come up, drops %v", m.Drops())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+/*
+ * Two pairs of VAPs sharing a Geneve tunnel and VNI, with independent
+ * sessions
+ */
+func TestGeneveSessions(t *testing.T) {
+	local := net.ParseIP("127.0.0.1")
+	peer := net.ParseIP("127.0.0.2")
+
+	m := NewGeneveManager()
+	m.Transport().Port = freeUDPPort(t)
+	if err := m.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	vaps := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.1.1"), net.ParseIP("10.0.1.2")}
+	var sessions []*Session
+	for i := 0; i < len(vaps); i += 2 {
+		tun := GeneveTunnel{Peer: peer, Local: local, VNI: 100, PeerVAP: vaps[i+1], LocalVAP: vaps[i]}
+		if i == 0 {
+			tun.PeerMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+			tun.LocalMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+		}
+		a, err := m.AddGeneveSession(tun, BfdStatusDefaults, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tun.Peer, tun.Local = local, peer
+		tun.PeerVAP, tun.LocalVAP = tun.LocalVAP, tun.PeerVAP
+		tun.PeerMAC, tun.LocalMAC = tun.LocalMAC, tun.PeerMAC
+		b, err := m.AddGeneveSession(tun, BfdStatusDefaults, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, a, b)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range sessions {
+		for s.State() != STATE_UP {
+			if time.Now().After(deadline) {
+				t.Fatalf("Sessions did not come up, drops %v", m.Drops())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+/*
+ * A tail follows a head it never sends anything to
+ */
+func TestMultipointSessions(t *testing.T) {
+	local := net.ParseIP("127.0.0.1")
+
+	tails := NewManager()
+	tails.Transport().Port = freeUDPPort(t)
+	if err := tails.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer tails.Close()
+
+	heads := NewManager()
+	heads.Transport().Port = tails.Transport().Port
+	defer heads.Close()
+
+	for _, group := range []string{"127.0.0.1", "224.0.0.250"} {
+		if ip := net.ParseIP(group); ip.IsMulticast() {
+			lo, err := net.InterfaceByName("lo")
+			if err != nil {
+				t.Skip("No loopback interface:", err)
+			}
+			if err := tails.JoinGroup(ip, lo.Index); err != nil {
+				t.Skip("Multicast unavailable on loopback:", err)
+			}
+		}
+
+		status := BfdStatusDefaults
+		status.DesiredMinTxInterval = 10000
+		head, err := heads.AddMultipointHead(net.ParseIP(group), local, 0, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		up := make(chan bool, 1)
+		down := make(chan BfdDiagnostic, 1)
+		tail, err := tails.AddMultipointTail(local, head.Status().LocalDiscr, nil, 0, BfdStatusDefaults, func(s *Session, old BfdState, new BfdState) {
+			switch new {
+			case STATE_UP:
+				up <- true
+			case STATE_DOWN:
+				down <- s.Status().LocalDiag
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-up:
+		case <-time.After(time.Second):
+			t.Fatalf("Tail did not come up through %s, drops %v", group, tails.Drops())
+		}
+
+		heads.RemoveSession(head)
+		select {
+		case diag := <-down:
+			if diag != DIAG_TIME_EXPIRED {
+				t.Errorf("Tail diagnostic mismatch through %s, got %d", group, diag)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Tail did not detect head failure through %s", group)
+		}
+		tails.RemoveSession(tail)
+	}
+}
+
+/*
+ * Unaffiliated echo packets looped back by the local stack (needs
+ * CAP_NET_RAW)
+ */
+func TestUnaffiliatedEchoTransport(t *testing.T) {
+	local := net.ParseIP("127.0.0.1")
+
+	m := NewUnaffiliatedEchoManager()
+	if err := m.Listen(); err != nil {
+		t.Skip("Echo port unavailable:", err)
+	}
+	defer m.Close()
+
+	s, err := m.AddSession(local, local, 0, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Skip("Raw sockets unavailable:", err)
+	}
+	waitForState(t, s, STATE_UP)
+}
+
+/*
+ * Egress sessions send to the multihop port of the ingress, on which an
+ * MPLS manager listens along with the single hop port
+ */
+func TestLspReturnTransport(t *testing.T) {
+	ingress := NewMplsManager()
+	defer ingress.Close()
+	ingress.Transport().Port = freeUDPPort(t)
+	ingress.LspReturnTransport().Port = freeUDPPort(t)
+	if err := ingress.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	egress := NewMplsManager()
+	defer egress.Close()
+	egress.LspReturnTransport().Port = ingress.LspReturnTransport().Port
+
+	// No session on the ingress, its packets are counted as drops
+	req := lspPingTests[0].Packet
+	if _, _, err := egress.AcceptLspPing(&req, net.IPv4(127, 0, 0, 1), BfdStatusDefaults, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for ingress.Drops()[ErrUnknownDiscriminator] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Egress packets did not reach the return port, drops %v", ingress.Drops())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package bfd
+
+import (
+	"net"
+)
+
+/*
+ * Create a manager for Unaffiliated BFD Echo sessions (RFC 9747),
+ * checking forwarding through neighbours which do not run BFD
+ *
+ * Each session sends control packets addressed to its own local
+ * address through the neighbour given as peer, which routes them
+ * straight back. The session thus talks to itself: it comes Up once
+ * its packets loop back, and goes Down after the Detection Time
+ * without them. Sessions need a local IPv4 address, and the neighbour
+ * is found by routing so the interface is ignored.
+ *
+ * The manager has its own discriminators, and listens on the echo
+ * port: it cannot be used alongside Manager.ListenEcho.
+ */
+func NewUnaffiliatedEchoManager() *Manager {
+	m := newManager(false)
+	m.loopback = true
+	m.transport = NewEchoTransport(m.handle)
+	m.transport.Drop = m.countDrop
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return DialEcho(local, peer)
+	}
+
+	return m
+}
+
+/*
+ * Looped back packets come from ourselves, and carry our own
+ * discriminator in both fields once the session learned it
+ */
+func (d *demux) lookupLoopback(source net.IP, p *BfdControlPacket) (*Session, error) {
+	if p.YourDiscriminator != 0 && p.YourDiscriminator != p.MyDiscriminator {
+		return nil, ErrUnknownDiscriminator
+	}
+
+	s, ok := d.byDiscr[p.MyDiscriminator]
+	if !ok {
+		return nil, ErrUnknownDiscriminator
+	}
+	if d.keys[s].local != source.String() {
+		return nil, ErrAddressMismatch
+	}
+	return s, nil
+}
@@ -0,0 +1,131 @@
+package bfd
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ * Stands in for a neighbour routing our echo packets back to us
+ */
+type loopSender struct {
+	m       *Manager
+	local   net.IP
+	forward atomic.Bool
+}
+
+func (l *loopSender) Transmit(p *BfdControlPacket) error {
+	if !l.forward.Load() {
+		return nil
+	}
+
+	looped, err := Decode(p.Marshal())
+	if err != nil {
+		return err
+	}
+	return l.m.Receive(&ReceivedPacket{Packet: looped, Peer: &net.UDPAddr{IP: l.local, Port: 49152}, Local: l.local, TTL: 254})
+}
+
+func (l *loopSender) Close() error {
+	return nil
+}
+
+func TestUnaffiliatedEcho(t *testing.T) {
+	local := net.ParseIP("192.0.2.1")
+	neighbour := net.ParseIP("192.0.2.2")
+
+	m := NewUnaffiliatedEchoManager()
+	defer m.Close()
+	l := &loopSender{m: m, local: local}
+	l.forward.Store(true)
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return l, nil
+	}
+
+	if _, err := m.AddSession(neighbour, nil, 0, BfdStatusDefaults, nil); !errors.Is(err, ErrNoLocalAddress) {
+		t.Errorf("Session without local address accepted, got %v", err)
+	}
+
+	down := make(chan BfdDiagnostic, 1)
+	status := BfdStatusDefaults
+	status.DesiredMinTxInterval = 10000
+	status.RequiredMinRxInterval = 10000
+	s, err := m.AddSession(neighbour, local, 0, status, func(s *Session, old BfdState, new BfdState) {
+		if old == STATE_UP {
+			select {
+			case down <- s.Status().LocalDiag:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, s, STATE_UP)
+	// Detection time only drops from the slow start interval once the
+	// session heard its own faster packets
+	detect := time.Duration(status.DetectMult) * microseconds(status.DesiredMinTxInterval)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.DetectionTime() != detect {
+		if time.Now().After(deadline) {
+			t.Fatalf("Detection time did not drop to %v, got %v", detect, s.DetectionTime())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := s.Status(); got.RemoteDiscr != got.LocalDiscr {
+		t.Errorf("Session did not learn its own discriminator, got %#v", got)
+	}
+
+	// The neighbour stops forwarding
+	l.forward.Store(false)
+	select {
+	case diag := <-down:
+		if diag != DIAG_TIME_EXPIRED {
+			t.Errorf("Diagnostic mismatch, expected %d, got %d", DIAG_TIME_EXPIRED, diag)
+		}
+	case <-time.After(10 * detect):
+		t.Errorf("Session did not go down within %v", 10*detect)
+	}
+}
+
+func TestUnaffiliatedEchoDemux(t *testing.T) {
+	local := net.ParseIP("192.0.2.1")
+
+	m := NewUnaffiliatedEchoManager()
+	defer m.Close()
+	m.dial = func(local net.IP, peer net.IP, ifIndex int) (sessionSender, error) {
+		return tailSender{}, nil
+	}
+
+	s, err := m.AddSession(net.ParseIP("192.0.2.2"), local, 0, BfdStatusDefaults, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discr := s.Status().LocalDiscr
+
+	p := BfdControlPacketDefaults
+	p.MyDiscriminator = discr
+	r := &ReceivedPacket{Packet: &p, Peer: &net.UDPAddr{IP: local, Port: 49152}}
+	if err := m.Receive(r); err != nil || s.State() != STATE_INIT {
+		t.Errorf("Looped back packet not delivered: %v", err)
+	}
+
+	r.Peer.IP = net.ParseIP("192.0.2.2")
+	if err := m.Receive(r); !errors.Is(err, ErrAddressMismatch) {
+		t.Errorf("Packet from the neighbour accepted, got %v", err)
+	}
+
+	r.Peer.IP = local
+	p.YourDiscriminator = discr + 1
+	if err := m.Receive(r); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Packet for another session accepted, got %v", err)
+	}
+	p.MyDiscriminator = discr + 1
+	if err := m.Receive(r); !errors.Is(err, ErrUnknownDiscriminator) {
+		t.Errorf("Packet from another session accepted, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package bfd
+
+import (
+	"time"
+)
+
+/*
+ * How long an unsolicited session may stay down before it is removed,
+ * in microseconds, when its template does not say
+ */
+const unsolicitedTimeout = time.Duration(60000000)
+
+/*
+ * Settings of the passive sessions created on an interface by
+ * unsolicited BFD (RFC 9468)
+ */
+type UnsolicitedTemplate struct {
+	Status      BfdStatus       // Initial state variables, LocalDiscr is allocated
+	KeyChain    *KeyChain       // Optional, authenticates the peer before a session is kept
+	MaxSessions int             // Zero for no limit
+	Timeout     time.Duration   // Time spent not Up before removal, in microseconds
+	OnChange    StateChangeFunc // Optional, called for all the sessions
+}
+
+/*
+ * An unsolicited session, removed when its timer expires
+ */
+type unsolicitedSession struct {
+	ifIndex int
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+/*
+ * The teardown timer only runs while the session is not Up
+ */
+func (u *unsolicitedSession) stateChange(old BfdState, new BfdState) {
+	if new == STATE_UP {
+		u.timer.Stop()
+	} else if old == STATE_UP {
+		u.timer.Reset(u.timeout)
+	}
+}
+
+/*
+ * Create passive sessions for peers sending an initial Down packet on
+ * the interface, or on any interface without a template of its own
+ * when ifIndex is zero
+ *
+ * The peer is expected to run an active session towards the receiving
+ * address. A session which is not Up for tmpl.Timeout is removed.
+ */
+func (m *Manager) EnableUnsolicited(ifIndex int, tmpl UnsolicitedTemplate) error {
+	if m.multihop || m.micro || m.overlay || m.loopback || m.mpls {
+		return ErrUnsolicitedUnsupported
+	}
+	if tmpl.Timeout == 0 {
+		tmpl.Timeout = unsolicitedTimeout
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.templates[ifIndex] = tmpl
+	return nil
+}
+
+/*
+ * Stop creating sessions on the interface, and remove those that were
+ */
+func (m *Manager) DisableUnsolicited(ifIndex int) {
+	var sessions []*Session
+
+	m.mu.Lock()
+	delete(m.templates, ifIndex)
+	for s, u := range m.unsolicited {
+		if u.ifIndex == ifIndex {
+			sessions = append(sessions, s)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		m.RemoveSession(s)
+	}
+}
+
+/*
+ * Report whether a session was created by unsolicited BFD
+ */
+func (m *Manager) Unsolicited(s *Session) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.unsolicited[s]
+	return ok
+}
+
+/*
+ * Deliver a packet matching no session to a new unsolicited one
+ */
+func (m *Manager) receiveUnsolicited(key sessionKey, r *ReceivedPacket) error {
+	s, err := m.addUnsolicited(key, r)
+	if err == nil {
+		// Only a peer passing authentication gets to keep its session
+		if err = s.Receive(r.Packet); err != nil {
+			m.RemoveSession(s)
+		}
+	}
+	if err != nil {
+		m.countDrop(err, r.Peer)
+	}
+	return err
+}
+
+func (m *Manager) addUnsolicited(key sessionKey, r *ReceivedPacket) (*Session, error) {
+	if r.Packet.State != STATE_DOWN || r.Packet.Multipoint {
+		return nil, ErrNoSession
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ifIndex := r.IfIndex
+	tmpl, ok := m.templates[ifIndex]
+	if !ok {
+		ifIndex = 0
+		tmpl, ok = m.templates[ifIndex]
+	}
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	if tmpl.MaxSessions > 0 {
+		n := 0
+		for _, u := range m.unsolicited {
+			if u.ifIndex == ifIndex {
+				n++
+			}
+		}
+		if n >= tmpl.MaxSessions {
+			return nil, ErrUnsolicitedLimit
+		}
+	}
+
+	u := &unsolicitedSession{ifIndex: ifIndex, timeout: microseconds(tmpl.Timeout)}
+	status := tmpl.Status
+	status.LocalDiscr = m.allocateDiscriminator()
+	s := NewSession(status, func(s *Session, old BfdState, new BfdState) {
+		u.stateChange(old, new)
+		if tmpl.OnChange != nil {
+			tmpl.OnChange(s, old, new)
+		}
+	})
+	if tmpl.KeyChain != nil {
+		s.SetKeyChain(tmpl.KeyChain)
+	}
+
+	dial := func() (sessionSender, error) {
+		return m.dial(r.Local, r.Peer.IP, r.IfIndex)
+	}
+	if _, err := m.startSession(key, dial, s); err != nil {
+		return nil, err
+	}
+
+	u.timer = m.afterFunc(u.timeout, func() {
+		// The session may have come up as the timer fired
+		if s.State() != STATE_UP {
+			m.RemoveSession(s)
+		}
+	})
+	m.unsolicited[s] = u
+	return s, nil
+}
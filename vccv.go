@@ -0,0 +1,142 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+/*
+ * Channel Types of the PW Associated Channel carrying BFD (RFC 5885)
+ */
+const (
+	PW_ACH_CHANNEL_BFD  = 0x0007 // BFD Control, without IP/UDP headers
+	PW_ACH_CHANNEL_IPV4 = 0x0021 // IPv4, with the BFD packet inside
+	PW_ACH_CHANNEL_IPV6 = 0x0057 // IPv6, with the BFD packet inside
+)
+
+/*
+ * VCCV Connectivity Verification types for BFD, as advertised by the
+ * PW signaling (RFC 5885 section 3)
+ */
+type VccvCVType uint8
+
+const (
+	VCCV_CV_BFD_IP_UDP_FAULT        VccvCVType = 0x04 // IP/UDP encapsulated, fault detection only
+	VCCV_CV_BFD_IP_UDP_FAULT_STATUS VccvCVType = 0x08 // IP/UDP encapsulated, fault detection and status signaling
+	VCCV_CV_BFD_PW_ACH_FAULT        VccvCVType = 0x10 // PW-ACH encapsulated, fault detection only
+	VCCV_CV_BFD_PW_ACH_FAULT_STATUS VccvCVType = 0x20 // PW-ACH encapsulated, fault detection and status signaling
+)
+
+/*
+ * Report whether the CV type carries BFD with IP/UDP headers
+ */
+func (t VccvCVType) IPUDP() bool {
+	return t&(VCCV_CV_BFD_IP_UDP_FAULT|VCCV_CV_BFD_IP_UDP_FAULT_STATUS) != 0
+}
+
+/*
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |0 0 0 1|Version|   Reserved    |         Channel Type          |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |   IP and UDP headers, destination in 127/8, if so negotiated  |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                      BFD Control Packet                       |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The PW label stack in front of the Associated Channel Header is left
+ * to the caller.
+ */
+type VccvPacket struct {
+	IPUDP   bool   // IP/UDP headers between the ACH and the control packet
+	Src     net.IP // Address of the sending PE, with IP/UDP headers
+	Dst     net.IP // 127/8, or ::ffff:127.0.0.0/104 for IPv6, random if nil
+	SrcPort uint16 // With IP/UDP headers
+	TTL     int    // With IP/UDP headers, 1 when sent
+	Packet  *BfdControlPacket
+}
+
+/*
+ * Marshal the Associated Channel Header and everything it carries
+ */
+func (v *VccvPacket) Marshal() []byte {
+	b := make([]byte, 4)
+	b[0] = 0x10 // Version 0
+
+	if !v.IPUDP {
+		binary.BigEndian.PutUint16(b[2:4], PW_ACH_CHANNEL_BFD)
+		return append(b, v.Packet.Marshal()...)
+	}
+
+	dst := v.Dst
+	if dst == nil {
+		dst = mplsDestination(v.Src)
+	}
+	ip := encapsulateIP(v.Src, dst, v.SrcPort, BFD_PORT_SINGLE_HOP, 1, false, v.Packet.Marshal())
+	binary.BigEndian.PutUint16(b[2:4], PW_ACH_CHANNEL_IPV6)
+	if ip[0]>>4 == 4 {
+		binary.BigEndian.PutUint16(b[2:4], PW_ACH_CHANNEL_IPV4)
+	}
+
+	return append(b, ip...)
+}
+
+/*
+ * Decode a control packet from the PW Associated Channel
+ *
+ * With IP/UDP headers, the packet must be addressed to port 3784 at a
+ * loopback destination, in the IP version given by the Channel Type.
+ */
+func DecodeVccv(data []byte) (*VccvPacket, error) {
+	v := &VccvPacket{}
+
+	if len(data) < 4 {
+		return nil, decodeError("Associated Channel Header", len(data), ErrPacketTooShort)
+	}
+	if data[0]>>4 != 1 {
+		return nil, decodeError("Associated Channel Header", 0, ErrInvalidEncapsulation)
+	}
+	if data[0]&0x0f != 0 {
+		return nil, decodeError("ACH Version", 0, ErrInvalidVersion)
+	}
+
+	var err error
+	switch binary.BigEndian.Uint16(data[2:4]) {
+	case PW_ACH_CHANNEL_BFD:
+		v.Packet, err = Decode(data[4:])
+		if err != nil {
+			return nil, shiftDecodeError(err, 4)
+		}
+		return v, nil
+	case PW_ACH_CHANNEL_IPV4:
+		if len(data) > 4 && data[4]>>4 != 4 {
+			return nil, decodeError("Channel Type", 2, ErrInvalidEncapsulation)
+		}
+	case PW_ACH_CHANNEL_IPV6:
+		if len(data) > 4 && data[4]>>4 != 6 {
+			return nil, decodeError("Channel Type", 2, ErrInvalidEncapsulation)
+		}
+	default:
+		return nil, decodeError("Channel Type", 2, ErrInvalidEncapsulation)
+	}
+
+	d, err := decapsulateUDP(data[4:])
+	if err != nil {
+		return nil, shiftDecodeError(err, 4)
+	}
+	if d.DstPort != BFD_PORT_SINGLE_HOP {
+		return nil, decodeError("Destination Port", 4, ErrInvalidEncapsulation)
+	}
+	if !loopbackDestinationValid(d.Dst) {
+		return nil, decodeError("Destination Address", 4, ErrInvalidEncapsulation)
+	}
+	v.IPUDP = true
+	v.Src, v.Dst, v.SrcPort, v.TTL = d.Src, d.Dst, d.SrcPort, d.TTL
+
+	v.Packet, err = Decode(d.Payload)
+	if err != nil {
+		return nil, shiftDecodeError(err, len(data)-len(d.Payload))
+	}
+	return v, nil
+}
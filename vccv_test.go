@@ -0,0 +1,161 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type vccvTestSet struct {
+	Name   string
+	Data   []byte
+	Packet VccvPacket
+}
+
+/*
+ * Associated Channel Headers as laid out by RFC 4385 section 3, with
+ * the Channel Types of RFC 5885 section 3
+ */
+var vccvTests = []vccvTestSet{
+	{
+		Name: "PW-ACH",
+		Data: []byte{
+			0x10, 0x00, 0x00, 0x07, // ACH Version 0, Channel Type: BFD Control
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: VccvPacket{
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+	{
+		Name: "IPv4",
+		Data: []byte{
+			0x10, 0x00, 0x00, 0x21, // ACH Version 0, Channel Type: IPv4
+			0x45, 0x00, 0x00, 0x34, // IPv4, Total Length 52
+			0x00, 0x00, 0x40, 0x00, // Don't Fragment
+			0x01, 0x11, 0x38, 0xb7, // TTL 1, UDP, Header Checksum
+			0xc0, 0x00, 0x02, 0x01, // Source 192.0.2.1
+			0x7f, 0x00, 0x00, 0x01, // Destination 127.0.0.1
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0x47, 0xeb, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: VccvPacket{
+			IPUDP:   true,
+			Src:     net.IP{192, 0, 2, 1},
+			Dst:     net.IP{127, 0, 0, 1},
+			SrcPort: 49152,
+			TTL:     1,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+	{
+		Name: "IPv6",
+		Data: []byte{
+			0x10, 0x00, 0x00, 0x57, // ACH Version 0, Channel Type: IPv6
+			0x60, 0x00, 0x00, 0x00, // IPv6
+			0x00, 0x20, 0x11, 0x01, // Payload Length 32, UDP, Hop Limit 1
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, // Source 2001:db8::1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Destination ::ffff:127.0.0.1
+			0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01,
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0xdc, 0x32, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: VccvPacket{
+			IPUDP:   true,
+			Src:     net.ParseIP("2001:db8::1"),
+			Dst:     net.ParseIP("::ffff:127.0.0.1"),
+			SrcPort: 49152,
+			TTL:     1,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+}
+
+func TestDecodeVccv(t *testing.T) {
+	for _, e := range vccvTests {
+		got, err := DecodeVccv(e.Data)
+		if err != nil {
+			t.Errorf("Error decoding for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("VCCV mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+func TestMarshalVccv(t *testing.T) {
+	for _, e := range vccvTests {
+		if got := e.Packet.Marshal(); !bytes.Equal(e.Data, got) {
+			t.Errorf("VCCV mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Data, got)
+		}
+	}
+}
+
+func TestVccvCVType(t *testing.T) {
+	for cv, ipudp := range map[VccvCVType]bool{
+		VCCV_CV_BFD_IP_UDP_FAULT:        true,
+		VCCV_CV_BFD_IP_UDP_FAULT_STATUS: true,
+		VCCV_CV_BFD_PW_ACH_FAULT:        false,
+		VCCV_CV_BFD_PW_ACH_FAULT_STATUS: false,
+	} {
+		if cv.IPUDP() != ipudp {
+			t.Errorf("CV type %#02x with IP/UDP headers: expected %v", cv, ipudp)
+		}
+	}
+}
+
+func TestDecodeInvalidVccv(t *testing.T) {
+	tests := []struct {
+		Name   string
+		IPUDP  bool
+		Modify func(b []byte) []byte
+		Err    error
+	}{
+		{"Truncated header", false, func(b []byte) []byte { return b[:3] }, ErrPacketTooShort},
+		{"Not an ACH", false, func(b []byte) []byte { b[0] = 0x00; return b }, ErrInvalidEncapsulation},
+		{"ACH version", false, func(b []byte) []byte { b[0] = 0x11; return b }, ErrInvalidVersion},
+		{"Channel Type", false, func(b []byte) []byte { b[3] = 0x08; return b }, ErrInvalidEncapsulation},
+		{"Truncated BFD", false, func(b []byte) []byte { return b[:len(b)-1] }, ErrPacketTooShort},
+		{"IP version", true, func(b []byte) []byte { b[3] = PW_ACH_CHANNEL_IPV6; return b }, ErrInvalidEncapsulation},
+		{"IP checksum", true, func(b []byte) []byte { b[4+12]++; return b }, ErrInvalidEncapsulation},
+		{"Truncated IP BFD", true, func(b []byte) []byte { return b[:len(b)-1] }, ErrInvalidLength},
+	}
+
+	for _, e := range tests {
+		data := vccvTests[0].Data
+		if e.IPUDP {
+			data = vccvTests[1].Data
+		}
+		_, err := DecodeVccv(e.Modify(append([]byte{}, data...)))
+		if !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+	}
+
+	v := vccvTests[1].Packet
+	v.Dst = net.IPv4(192, 0, 2, 2)
+	if _, err := DecodeVccv(v.Marshal()); !errors.Is(err, ErrInvalidEncapsulation) {
+		t.Errorf("Non-loopback destination accepted, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package bfd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const BFD_PORT_VXLAN = 4789 // VXLAN, RFC 7348
+
+/*
+ * Inner destination MAC address of BFD packets in VXLAN, 00-00-5E-90-01-01
+ * as assigned by IANA (RFC 8971 sections 4 and 9)
+ */
+var BFD_VXLAN_MAC = net.HardwareAddr{0x00, 0x00, 0x5e, 0x90, 0x01, 0x01}
+
+/*
+ *  0                   1                   2                   3
+ *  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |R|R|R|R|I|R|R|R|            Reserved                           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                VXLAN Network Identifier (VNI) |   Reserved    |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                  Inner Ethernet, IP and UDP headers           |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ * |                     BFD Control Packet                        |
+ * +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+ *
+ * The outer IP and UDP headers are those of the datagram.
+ */
+type VxlanPacket struct {
+	VNI     uint32
+	SrcMAC  net.HardwareAddr // MAC address of the originating VTEP
+	Src     net.IP           // Address of the originating VTEP
+	Dst     net.IP           // 127/8, or ::ffff:127.0.0.0/104 for IPv6
+	SrcPort uint16           // Inner UDP source port
+	TTL     int              // Inner TTL / Hop Limit, 255 when sent
+	Packet  *BfdControlPacket
+}
+
+/*
+ * Loopback destination of the inner packet for a VTEP address
+ * (RFC 8971 section 4)
+ */
+func vxlanDestination(src net.IP) net.IP {
+	if src.To4() != nil {
+		return net.IPv4(127, 0, 0, 1).To4()
+	}
+	return net.ParseIP("::ffff:127.0.0.1")
+}
+
+/*
+ * Marshal the VXLAN header and everything it encapsulates
+ */
+func (v *VxlanPacket) Marshal() []byte {
+	dst := v.Dst
+	if dst == nil {
+		dst = vxlanDestination(v.Src)
+	}
+
+	ethertype := uint16(0x86dd)
+	if v.Src.To4() != nil {
+		ethertype = 0x0800
+	}
+
+	b := make([]byte, 22)
+	b[0] = 0x08 // I flag, VNI is valid
+	binary.BigEndian.PutUint32(b[4:8], v.VNI<<8)
+	copy(b[8:14], BFD_VXLAN_MAC)
+	copy(b[14:20], v.SrcMAC)
+	binary.BigEndian.PutUint16(b[20:22], ethertype)
+
+	return append(b, encapsulateUDP(v.Src, dst, v.SrcPort, BFD_PORT_SINGLE_HOP, v.Packet.Marshal())...)
+}
+
+/*
+ * Decode a VXLAN encapsulated control packet (RFC 8971 section 5)
+ *
+ * Besides the control packet itself being valid, the inner frame must
+ * be addressed to BFD_VXLAN_MAC, the inner packet to a loopback address
+ * and port 3784 with a TTL / Hop Limit of 255.
+ */
+func DecodeVxlan(data []byte) (*VxlanPacket, error) {
+	v := &VxlanPacket{}
+
+	if len(data) < 22 {
+		return nil, decodeError("VXLAN Header", len(data), ErrPacketTooShort)
+	}
+	if data[0]&0x08 == 0 {
+		return nil, decodeError("VXLAN Flags", 0, ErrInvalidEncapsulation)
+	}
+	v.VNI = binary.BigEndian.Uint32(data[4:8]) >> 8
+
+	if !bytes.Equal(data[8:14], BFD_VXLAN_MAC) {
+		return nil, decodeError("Inner Destination MAC", 8, ErrInvalidEncapsulation)
+	}
+	v.SrcMAC = net.HardwareAddr(append([]byte{}, data[14:20]...))
+
+	switch binary.BigEndian.Uint16(data[20:22]) {
+	case 0x0800, 0x86dd:
+	default:
+		return nil, decodeError("Inner EtherType", 20, ErrInvalidEncapsulation)
+	}
+
+	d, err := decapsulateUDP(data[22:])
+	if err != nil {
+		return nil, shiftDecodeError(err, 22)
+	}
+	if !loopbackDestinationValid(d.Dst) {
+		return nil, decodeError("Inner Destination Address", 22, ErrInvalidEncapsulation)
+	}
+	if d.DstPort != BFD_PORT_SINGLE_HOP {
+		return nil, decodeError("Inner Destination Port", 22, ErrInvalidEncapsulation)
+	}
+	if d.TTL != BFD_TTL {
+		return nil, decodeError("Inner TTL", 22, ErrInvalidTTL)
+	}
+	v.Src, v.Dst, v.SrcPort, v.TTL = d.Src, d.Dst, d.SrcPort, d.TTL
+
+	v.Packet, err = Decode(d.Payload)
+	if err != nil {
+		return nil, shiftDecodeError(err, len(data)-len(d.Payload))
+	}
+
+	return v, nil
+}
+
+/*
+ * Create a transport for VXLAN encapsulated control packets
+ *
+ * The outer TTL is not checked, as VTEPs are usually several hops
+ * apart; the inner one is checked by DecodeVxlan.
+ */
+func NewVxlanTransport(handler PacketHandler) *Transport {
+	return &Transport{
+		Port:    BFD_PORT_VXLAN,
+		MinTTL:  0,
+		Handler: handler,
+		decode:  decodeVxlanPacket,
+	}
+}
+
+func decodeVxlanPacket(data []byte, r *ReceivedPacket) error {
+	v, err := DecodeVxlan(data)
+	if err != nil {
+		return err
+	}
+
+	r.Packet = v.Packet
+	r.VNI = v.VNI
+	return nil
+}
+
+/*
+ * Create a manager for BFD sessions between VTEPs, identified by the
+ * remote VTEP address and VNI
+ *
+ * mac is the MAC address of the local VTEP, and AddSession creates
+ * sessions on the management VNI.
+ */
+func NewVxlanManager(mac net.HardwareAddr, managementVNI uint32) *Manager {
+	m := newManager(false)
+	m.overlay = true
+	m.vni = managementVNI
+	m.transport = NewVxlanTransport(m.handle)
+	m.transport.Drop = m.countDrop
+	m.dialVNI = func(local net.IP, peer net.IP, vni uint32) (sessionSender, error) {
+		sender, err := m.transport.Dial(local, peer)
+		if err != nil {
+			return nil, err
+		}
+		return &VxlanSender{sender: sender, vni: vni, mac: mac, local: local}, nil
+	}
+
+	return m
+}
+
+/*
+ * Create and start a session towards the VTEP at peer on a VNI, with
+ * local as the address of the local VTEP
+ */
+func (m *Manager) AddVNISession(peer net.IP, local net.IP, vni uint32, status BfdStatus, fn StateChangeFunc) (*Session, error) {
+	if !m.overlay {
+		return nil, ErrSessionUnsupported
+	}
+	if local == nil || local.IsUnspecified() {
+		return nil, ErrNoLocalAddress
+	}
+
+	dial := func() (sessionSender, error) {
+		return m.dialVNI(local, peer, vni)
+	}
+	return m.addSession(m.key(peer, local, 0, vni), dial, status, fn)
+}
+
+/*
+ * Transmits VXLAN encapsulated control packets to a remote VTEP
+ */
+type VxlanSender struct {
+	sender *Sender
+	vni    uint32
+	mac    net.HardwareAddr
+	local  net.IP
+}
+
+/*
+ * Send a control packet, usable as a TransmitFunc
+ */
+func (s *VxlanSender) Transmit(p *BfdControlPacket) error {
+	if err := p.validateAuth(); err != nil {
+		return err
+	}
+
+	v := &VxlanPacket{
+		VNI:     s.vni,
+		SrcMAC:  s.mac,
+		Src:     s.local,
+		SrcPort: uint16(s.sender.LocalAddr().Port),
+		Packet:  p,
+	}
+
+	_, err := s.sender.conn.Write(v.Marshal())
+	return err
+}
+
+func (s *VxlanSender) Close() error {
+	return s.sender.Close()
+}
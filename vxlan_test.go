@@ -0,0 +1,151 @@
+package bfd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type vxlanTestSet struct {
+	Name   string
+	Data   []byte
+	Packet VxlanPacket
+}
+
+var vxlanTests = []vxlanTestSet{
+	{
+		Name: "IPv4",
+		Data: []byte{
+			0x08, 0x00, 0x00, 0x00, // Flags: I
+			0xab, 0xcd, 0xef, 0x00, // VNI: 0xabcdef
+			0x00, 0x00, 0x5e, 0x90, 0x01, 0x01, // Inner Destination MAC: 00-00-5E-90-01-01
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // Inner Source MAC
+			0x08, 0x00, // EtherType: IPv4
+			0x45, 0x00, 0x00, 0x34, // IPv4, Total Length 52
+			0x00, 0x00, 0x40, 0x00, // Don't Fragment
+			0xff, 0x11, 0x3a, 0xb6, // TTL 255, UDP, Header Checksum
+			0xc0, 0x00, 0x02, 0x01, // Source 192.0.2.1
+			0x7f, 0x00, 0x00, 0x01, // Destination 127.0.0.1
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0x47, 0xeb, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: VxlanPacket{
+			VNI:     0xabcdef,
+			SrcMAC:  net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+			Src:     net.IP{192, 0, 2, 1},
+			Dst:     net.IP{127, 0, 0, 1},
+			SrcPort: 49152,
+			TTL:     255,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+	{
+		Name: "IPv6",
+		Data: []byte{
+			0x08, 0x00, 0x00, 0x00, // Flags: I
+			0xab, 0xcd, 0xef, 0x00, // VNI: 0xabcdef
+			0x00, 0x00, 0x5e, 0x90, 0x01, 0x01, // Inner Destination MAC: 00-00-5E-90-01-01
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // Inner Source MAC
+			0x86, 0xdd, // EtherType: IPv6
+			0x60, 0x00, 0x00, 0x00, // IPv6
+			0x00, 0x20, 0x11, 0xff, // Payload Length 32, UDP, Hop Limit 255
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, // Source 2001:db8::1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Destination ::ffff:127.0.0.1
+			0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01,
+			0xc0, 0x00, 0x0e, 0xc8, // Ports 49152 -> 3784
+			0x00, 0x20, 0xdc, 0x32, // UDP Length 32, Checksum
+			0x20, 0x40, 0x03, 0x18, 0x00, 0x00, 0x00, 0x01, // My Discriminator: 1
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0x42, 0x40, // Your Discriminator: 0
+			0x00, 0x0f, 0x42, 0x40, 0x00, 0x00, 0x00, 0x00,
+		},
+		Packet: VxlanPacket{
+			VNI:     0xabcdef,
+			SrcMAC:  net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+			Src:     net.ParseIP("2001:db8::1"),
+			Dst:     net.ParseIP("::ffff:127.0.0.1"),
+			SrcPort: 49152,
+			TTL:     255,
+			Packet: &BfdControlPacket{
+				Version: 1, State: STATE_DOWN, DetectMult: 3, MyDiscriminator: 1,
+				DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 1000000,
+			},
+		},
+	},
+}
+
+func TestDecodeVxlan(t *testing.T) {
+	for _, e := range vxlanTests {
+		got, err := DecodeVxlan(e.Data)
+		if err != nil {
+			t.Errorf("Error decoding for test '%s': %v", e.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&e.Packet, got) {
+			t.Errorf("VXLAN mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Packet, got)
+		}
+	}
+}
+
+func TestMarshalVxlan(t *testing.T) {
+	for _, e := range vxlanTests {
+		if got := e.Packet.Marshal(); !bytes.Equal(e.Data, got) {
+			t.Errorf("VXLAN mismatch for test '%s', \nexpected:\n%#v\n\ngot:\n%#v\n\n", e.Name, e.Data, got)
+		}
+	}
+}
+
+func TestDecodeInvalidVxlan(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Modify func(b []byte) []byte
+		Err    error
+	}{
+		{"Truncated", func(b []byte) []byte { return b[:20] }, ErrPacketTooShort},
+		{"I flag clear", func(b []byte) []byte { b[0] = 0; return b }, ErrInvalidEncapsulation},
+		{"Tenant MAC", func(b []byte) []byte { b[13]++; return b }, ErrInvalidEncapsulation},
+		{"Not IP", func(b []byte) []byte { b[20], b[21] = 0x08, 0x06; return b }, ErrInvalidEncapsulation},
+		{"IP checksum", func(b []byte) []byte { b[22+12]++; return b }, ErrInvalidEncapsulation},
+		{"UDP checksum", func(b []byte) []byte { b[len(b)-1]++; return b }, ErrInvalidEncapsulation},
+		{"Truncated BFD", func(b []byte) []byte { return b[:len(b)-1] }, ErrInvalidLength},
+	}
+
+	for _, e := range tests {
+		_, err := DecodeVxlan(e.Modify(append([]byte{}, vxlanTests[0].Data...)))
+		if !errors.Is(err, e.Err) {
+			t.Errorf("Error mismatch for test '%s', expected '%v', got '%v'", e.Name, e.Err, err)
+		}
+	}
+
+	v := vxlanTests[0].Packet
+	v.Dst = net.IPv4(192, 0, 2, 2)
+	if _, err := DecodeVxlan(v.Marshal()); !errors.Is(err, ErrInvalidEncapsulation) {
+		t.Errorf("Non-loopback inner destination accepted, got %v", err)
+	}
+
+	// Inner TTL other than 255
+	b := append([]byte{}, vxlanTests[0].Data...)
+	b[22+8] = 254
+	b[22+10], b[22+11] = 0, 0
+	sum := checksum(b[22:42], 0)
+	b[22+10], b[22+11] = byte(sum>>8), byte(sum)
+	if _, err := DecodeVxlan(b); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Inner TTL of 254 accepted, got %v", err)
+	}
+
+	var de *DecodeError
+	b = append([]byte{}, vxlanTests[0].Data...)
+	b[48], b[49] = 0, 0 // No UDP checksum
+	b[50+2] = 0         // Detect Mult of the control packet
+	if _, err := DecodeVxlan(b); !errors.As(err, &de) || de.Offset != 52 {
+		t.Errorf("Offset not relative to the VXLAN header, got %v", err)
+	}
+}